@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+// Command names.
+const (
+	commandUp     = "up"
+	commandDown   = "down"
+	commandStatus = "status"
+	commandGoto   = "goto"
+	commandRedo   = "redo"
+	commandForce  = "force"
+	commandCreate = "create"
+)
+
+// command is a parsed subcommand, with its version or migration name argument, if any.
+type command struct {
+	name          string
+	version       int64
+	migrationName string
+}
+
+// parseCommand parses the subcommand and its arguments.
+func parseCommand(args []string) (command, error) {
+	if len(args) == 0 {
+		return command{}, fmt.Errorf("%w: missing command", errUsage)
+	}
+
+	cmd := command{name: args[0]}
+	args = args[1:]
+
+	var versionArg string
+
+	switch cmd.name {
+	case commandUp:
+		// The version is optional, up applies every migration by default.
+		cmd.version = migrate.Latest
+		if len(args) == 0 {
+			return cmd, nil
+		}
+		versionArg, args = args[0], args[1:]
+	case commandDown, commandGoto, commandForce:
+		if len(args) == 0 {
+			return command{}, fmt.Errorf("%w: %s requires a version", errUsage, cmd.name)
+		}
+		versionArg, args = args[0], args[1:]
+	case commandCreate:
+		if len(args) == 0 {
+			return command{}, fmt.Errorf("%w: %s requires a migration name", errUsage, cmd.name)
+		}
+		// The name can be given as separate words, such as create add users.
+		cmd.migrationName, args = strings.Join(args, " "), nil
+	case commandStatus, commandRedo:
+	default:
+		return command{}, fmt.Errorf("%w: unknown command %q", errUsage, cmd.name)
+	}
+
+	if len(args) > 0 {
+		return command{}, fmt.Errorf("%w: unexpected arguments %v", errUsage, args)
+	}
+
+	if versionArg != "" {
+		version, err := strconv.ParseInt(versionArg, 10, 64)
+		if err != nil || version < 0 {
+			return command{}, fmt.Errorf("%w: invalid version %q", errUsage, versionArg)
+		}
+		cmd.version = version
+	}
+
+	return cmd, nil
+}
+
+// execute runs the command. The result is returned even on errors, with the migrations that ran.
+func (c command) execute(ctx context.Context, migrator migrate.Migrator) (*result, error) {
+	res := &result{Command: c.name}
+
+	switch c.name {
+	case commandUp:
+		return res, res.run(ctx, migrator, migrate.DirectionUp, c.version)
+	case commandDown:
+		return res, res.run(ctx, migrator, migrate.DirectionDown, c.version)
+	case commandGoto:
+		report, err := migrator.Goto(ctx, c.version)
+		res.keepReport(report)
+		return res, err
+	case commandRedo:
+		if err := migrator.Redo(ctx); err != nil {
+			return res, err
+		}
+	case commandForce:
+		if err := migrator.Force(ctx, c.version); err != nil {
+			return res, err
+		}
+	}
+
+	return res, res.readStatus(ctx, migrator)
+}
+
+// connects tells whether the command runs against the database.
+func (c command) connects() bool {
+	return c.name != commandCreate
+}
+
+// create writes the files of a new migration in the migrations directory.
+func (c command) create(cfg config) (*result, error) {
+	scaffoldConfig := migrate.ScaffoldConfig{
+		Name:   c.migrationName,
+		Scheme: migrate.VersionScheme(cfg.scheme),
+	}
+
+	if cfg.format == formatGo {
+		stub := goStub(cfg.adapter)
+		scaffoldConfig.Go = &stub
+	}
+
+	res := &result{Command: c.name}
+
+	scaffold, err := migrate.NewScaffold(os.DirFS(cfg.dir), ".", scaffoldConfig)
+	if err != nil {
+		return res, err
+	}
+
+	if err := scaffold.Write(cfg.dir); err != nil {
+		return res, err
+	}
+
+	res.Created = &createdOutput{
+		Version: scaffold.Version,
+		Files:   make([]string, 0, len(scaffold.Files)),
+	}
+	for _, file := range scaffold.Files {
+		res.Created.Files = append(res.Created.Files, filepath.Join(cfg.dir, filepath.FromSlash(file.Path)))
+	}
+
+	return res, nil
+}
+
+// run applies or reverts the migrations, keeping the report.
+func (r *result) run(ctx context.Context, migrator migrate.Migrator, direction migrate.Direction, targetVersion int64) error {
+	report, err := migrator.Run(ctx, direction, targetVersion)
+	r.keepReport(report)
+	return err
+}
+
+// keepReport stores the report of the migrations that ran in the result, if any.
+func (r *result) keepReport(report *migrate.Report) {
+	if report != nil {
+		r.Report = newReportOutput(report)
+	}
+}
+
+// readStatus stores the status of the database in the result.
+func (r *result) readStatus(ctx context.Context, migrator migrate.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.Status = newStatusOutput(status)
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is the configuration file read by -env, unless -config is set.
+const defaultConfigPath = "codemigrate.yaml"
+
+type (
+	// configFile is the YAML configuration file, defining named environments.
+	configFile struct {
+		Environments map[string]environment `yaml:"environments"`
+	}
+
+	// environment is the configuration of a database, such as local, staging or prod.
+	// Empty values keep the defaults of the flags.
+	environment struct {
+		// DSN may reference environment variables, as $NAME or ${NAME}. A literal $ is written $$.
+		DSN         string        `yaml:"dsn"`
+		Adapter     string        `yaml:"adapter"`
+		Dir         string        `yaml:"dir"`
+		Table       string        `yaml:"table"`
+		Schema      string        `yaml:"schema"`
+		LockTimeout time.Duration `yaml:"lock_timeout"`
+	}
+)
+
+// loadEnvironment reads the environment from the configuration file.
+// The migrations directory is relative to the file.
+func loadEnvironment(path, name string) (environment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return environment{}, fmt.Errorf("reading configuration: %w", err)
+	}
+	defer file.Close()
+
+	var cfgFile configFile
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&cfgFile); err != nil {
+		return environment{}, fmt.Errorf("%w: parsing %s: %w", errUsage, path, err)
+	}
+
+	env, ok := cfgFile.Environments[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(cfgFile.Environments))
+		return environment{}, fmt.Errorf("%w: environment %q not found in %s, use one of %v", errUsage, name, path, names)
+	}
+
+	env.DSN, err = expandEnv(env.DSN)
+	if err != nil {
+		return environment{}, fmt.Errorf("%w: environment %q: %w", errUsage, name, err)
+	}
+
+	if env.Dir != "" && !filepath.IsAbs(env.Dir) {
+		env.Dir = filepath.Join(filepath.Dir(path), env.Dir)
+	}
+
+	return env, nil
+}
+
+// expandEnv replaces the environment variables of the value, failing on unset ones,
+// so a missing secret isn't mistaken for an empty password.
+func expandEnv(value string) (string, error) {
+	var missing []string
+
+	expanded := os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unset environment variables %v", missing)
+	}
+
+	return expanded, nil
+}
+
+// apply sets the values of the environment in the configuration,
+// except for those given as flags, which take precedence.
+func (e environment) apply(cfg *config, flagsSet map[string]bool) {
+	set := func(flagName string, target *string, value string) {
+		if value != "" && !flagsSet[flagName] {
+			*target = value
+		}
+	}
+
+	set("dsn", &cfg.dsn, e.DSN)
+	set("adapter", &cfg.adapter, e.Adapter)
+	set("dir", &cfg.dir, e.Dir)
+	set("table", &cfg.tableName, e.Table)
+	set("schema", &cfg.schema, e.Schema)
+
+	if e.LockTimeout > 0 && !flagsSet["lock-timeout"] {
+		cfg.lockTimeout = e.LockTimeout
+	}
+}
+
+// qualifiedTableName returns the schema migrations table name, qualified by the schema, if any.
+// It's empty when both are, keeping the adapter's default.
+func (c config) qualifiedTableName() string {
+	if c.schema == "" || strings.Contains(c.tableName, ".") {
+		return c.tableName
+	}
+
+	tableName := c.tableName
+	if tableName == "" {
+		tableName = defaultTableName
+	}
+
+	return c.schema + "." + tableName
+}
+
+// connectionString returns the DSN, setting the search path to the schema, if any,
+// so unqualified names in the scripts refer to it.
+func (c config) connectionString() (string, error) {
+	if c.schema == "" {
+		return c.dsn, nil
+	}
+
+	if !strings.HasPrefix(c.dsn, "postgres://") && !strings.HasPrefix(c.dsn, "postgresql://") {
+		// Keyword/value connection strings quote values with single quotes, escaping them with a backslash.
+		escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(c.schema)
+		return fmt.Sprintf("%s search_path='%s'", c.dsn, escaped), nil
+	}
+
+	dsnURL, err := url.Parse(c.dsn)
+	if err != nil {
+		// The parsing error quotes the URL, with its password.
+		return "", errors.New("invalid connection URL")
+	}
+
+	query := dsnURL.Query()
+	query.Set("search_path", c.schema)
+	dsnURL.RawQuery = query.Encode()
+
+	return dsnURL.String(), nil
+}
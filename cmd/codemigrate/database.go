@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	pgxadapter "github.com/sonalys/codemigrate/database/postgres/pgx/adapter"
+	pqadapter "github.com/sonalys/codemigrate/database/postgres/pq/adapter"
+	"github.com/sonalys/codemigrate/migrate"
+
+	_ "github.com/lib/pq"
+)
+
+// Adapter names.
+const (
+	adapterPq  = "pq"
+	adapterPgx = "pgx"
+)
+
+// Formats of the migrations created by the create command.
+const (
+	formatSQL = "sql"
+	formatGo  = "go"
+)
+
+// defaultTableName is the schema migrations table name of the adapters.
+const defaultTableName = "schema_migrations"
+
+// appName identifies the command in the migration history.
+const appName = "codemigrate"
+
+// openMigrator connects to the database with the configured adapter, and loads the migrations of the directory.
+// The returned function closes the connection.
+func openMigrator(ctx context.Context, cfg config, logger *slog.Logger) (migrate.Migrator, func(), error) {
+	fileSystem := os.DirFS(cfg.dir)
+
+	if cfg.adapter == adapterPq {
+		return openPq(ctx, cfg, fileSystem, logger)
+	}
+	return openPgx(ctx, cfg, fileSystem, logger)
+}
+
+func openPq(ctx context.Context, cfg config, fileSystem fs.FS, logger *slog.Logger) (migrate.Migrator, func(), error) {
+	migrations, err := pqadapter.LoadScriptMigrations[*sql.Tx](fileSystem, ".")
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading migrations from %s: %w", cfg.dir, err)
+	}
+
+	dsn, err := cfg.connectionString()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("connecting to database: %w", err)
+	}
+
+	opts := []pqadapter.Option{
+		pqadapter.WithLogger(logger),
+		pqadapter.WithAppName(appName),
+	}
+	if tableName := cfg.qualifiedTableName(); tableName != "" {
+		opts = append(opts, pqadapter.WithTableName(tableName))
+	}
+	if cfg.lockTimeout > 0 {
+		opts = append(opts, pqadapter.WithLockTimeout(cfg.lockTimeout))
+	}
+
+	migrator, err := migrate.NewWithOptions(pqadapter.From(db, opts...), migrations, migrate.WithLogger(logger))
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+
+	return migrator, func() { _ = db.Close() }, nil
+}
+
+func openPgx(ctx context.Context, cfg config, fileSystem fs.FS, logger *slog.Logger) (migrate.Migrator, func(), error) {
+	migrations, err := pgxadapter.LoadScriptMigrations(fileSystem, ".")
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading migrations from %s: %w", cfg.dir, err)
+	}
+
+	dsn, err := cfg.connectionString()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, nil, fmt.Errorf("connecting to database: %w", err)
+	}
+
+	opts := []pgxadapter.Option{
+		pgxadapter.WithLogger(logger),
+		pgxadapter.WithAppName(appName),
+	}
+	if tableName := cfg.qualifiedTableName(); tableName != "" {
+		opts = append(opts, pgxadapter.WithTableName(tableName))
+	}
+	if cfg.lockTimeout > 0 {
+		opts = append(opts, pgxadapter.WithLockTimeout(cfg.lockTimeout))
+	}
+
+	migrator, err := migrate.NewWithOptions(pgxadapter.From(pool, opts...), migrations, migrate.WithLogger(logger))
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+
+	return migrator, pool.Close, nil
+}
+
+// goStub returns the Go migration stub of the adapter.
+func goStub(adapter string) migrate.GoStub {
+	if adapter == adapterPq {
+		return pqadapter.GoStub
+	}
+	return pgxadapter.GoStub
+}
@@ -0,0 +1,240 @@
+// Command codemigrate applies and reverts the SQL script migrations of a directory,
+// using the pq or pgx PostgreSQL adapter.
+//
+// Usage:
+//
+//	codemigrate [flags] <command> [arguments]
+//
+// Commands:
+//
+//	up [version]     apply the migrations up to the version, or all of them
+//	down <version>   revert the migrations down to the version, 0 reverts all of them
+//	status           print the current version, and the applied and pending migrations
+//	goto <version>   apply or revert the migrations until the database is at the version
+//	redo             revert the current migration, then apply it again
+//	force <version>  store the version and clear the dirty flag, after repairing the database by hand
+//	create <name>    create the files of a new migration in the directory, without connecting to the database
+//
+// With -env, the flags are read from the environment of the configuration file, codemigrate.yaml by default:
+//
+//	environments:
+//	  staging:
+//	    dsn: ${STAGING_DATABASE_URL}
+//	    adapter: pq
+//	    dir: migrations
+//	    table: schema_migrations
+//	    schema: app
+//	    lock_timeout: 30s
+//
+// Flags given on the command line take precedence over the file.
+//
+// Exit codes:
+//
+//	0  success
+//	1  any other error, such as a connection failure or invalid scripts
+//	2  invalid flags or arguments
+//	3  a migration failed
+//	4  the database is dirty, and must be repaired with force
+//	5  the migration lock couldn't be acquired in time
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+// Exit codes of the command.
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitMigrationFailed
+	exitDirty
+	exitLockTimeout
+)
+
+// errUsage when the flags or arguments are invalid.
+var errUsage = errors.New("invalid usage")
+
+// config is everything needed to connect to the database and load the migrations.
+type config struct {
+	dsn         string
+	adapter     string
+	dir         string
+	tableName   string
+	schema      string
+	lockTimeout time.Duration
+	// format and scheme describe the migrations created by the create command.
+	format string
+	scheme string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line, writing the result to stdout and diagnostics to stderr.
+// It returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		cfg        config
+		configPath string
+		envName    string
+		jsonMode   bool
+		verbose    bool
+	)
+
+	flags := flag.NewFlagSet("codemigrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&cfg.dsn, "dsn", os.Getenv("CODEMIGRATE_DSN"), "database connection string, defaults to $CODEMIGRATE_DSN")
+	flags.StringVar(&cfg.adapter, "adapter", adapterPgx, "database adapter, pq or pgx")
+	flags.StringVar(&cfg.dir, "dir", "migrations", "directory of the migration scripts")
+	flags.StringVar(&cfg.tableName, "table", "", "schema migrations table name, defaults to the adapter's")
+	flags.StringVar(&cfg.schema, "schema", "", "schema of the migrations, set as the search path and qualifying the table name")
+	flags.DurationVar(&cfg.lockTimeout, "lock-timeout", 0, "how long to wait for the migration lock, 0 waits forever")
+	flags.StringVar(&cfg.format, "format", formatSQL, "format of created migrations, sql scripts or a go file for the adapter")
+	flags.StringVar(&cfg.scheme, "scheme", string(migrate.VersionSequential), "version scheme of created migrations, sequential or timestamp, when the directory has none yet")
+	flags.StringVar(&configPath, "config", defaultConfigPath, "configuration file of the environments")
+	flags.StringVar(&envName, "env", os.Getenv("CODEMIGRATE_ENV"), "environment of the configuration file, defaults to $CODEMIGRATE_ENV")
+	flags.BoolVar(&jsonMode, "json", false, "print the result as JSON")
+	flags.BoolVar(&verbose, "verbose", false, "log the migration progress to stderr")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: codemigrate [flags] <up [version] | down <version> | status | goto <version> | redo | force <version> | create <name>>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	out := &output{stdout: stdout, stderr: stderr, json: jsonMode}
+
+	cmd, err := parseCommand(flags.Args())
+	if err == nil {
+		err = cfg.applyEnvironment(flags, configPath, envName)
+	}
+	if err == nil {
+		err = cfg.validate(cmd)
+	}
+	if err != nil {
+		out.fail("", err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	if !cmd.connects() {
+		result, err := cmd.create(cfg)
+		if err != nil {
+			out.failWith(result, err)
+			return exitCode(err)
+		}
+
+		out.print(result)
+		return exitOK
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	if verbose {
+		logger = slog.New(slog.NewTextHandler(stderr, nil))
+	}
+
+	migrator, closeDB, err := openMigrator(ctx, cfg, logger)
+	if err != nil {
+		out.fail(cmd.name, err)
+		return exitCode(err)
+	}
+	defer closeDB()
+
+	result, err := cmd.execute(ctx, migrator)
+	if err != nil {
+		out.failWith(result, err)
+		return exitCode(err)
+	}
+
+	out.print(result)
+	return exitOK
+}
+
+// applyEnvironment sets the values of the environment, if any, except for the flags given on the command line.
+func (c *config) applyEnvironment(flags *flag.FlagSet, configPath, envName string) error {
+	flagsSet := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		flagsSet[f.Name] = true
+	})
+
+	if envName == "" {
+		if flagsSet["config"] {
+			return fmt.Errorf("%w: -config requires -env", errUsage)
+		}
+		return nil
+	}
+
+	env, err := loadEnvironment(configPath, envName)
+	if err != nil {
+		return err
+	}
+
+	env.apply(c, flagsSet)
+	return nil
+}
+
+// validate checks the configuration for the command, before connecting to the database.
+func (c config) validate(cmd command) error {
+	if c.dsn == "" && cmd.connects() {
+		return fmt.Errorf("%w: missing -dsn", errUsage)
+	}
+
+	if c.adapter != adapterPq && c.adapter != adapterPgx {
+		return fmt.Errorf("%w: unknown adapter %q, use %s or %s", errUsage, c.adapter, adapterPq, adapterPgx)
+	}
+
+	if c.format != formatSQL && c.format != formatGo {
+		return fmt.Errorf("%w: unknown format %q, use %s or %s", errUsage, c.format, formatSQL, formatGo)
+	}
+
+	if scheme := migrate.VersionScheme(c.scheme); scheme != migrate.VersionSequential && scheme != migrate.VersionTimestamp {
+		return fmt.Errorf("%w: unknown scheme %q, use %s or %s", errUsage, c.scheme, migrate.VersionSequential, migrate.VersionTimestamp)
+	}
+
+	return nil
+}
+
+// exitCode returns the exit code for the error.
+func exitCode(err error) int {
+	var migrationErr *migrate.MigrationError
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, migrate.ErrDirty):
+		return exitDirty
+	case errors.Is(err, migrate.ErrLockTimeout):
+		return exitLockTimeout
+	case errors.As(err, &migrationErr),
+		errors.Is(err, migrate.ErrIrreversible),
+		errors.Is(err, migrate.ErrOutOfOrder),
+		errors.Is(err, migrate.ErrVetoed):
+		// Refused and vetoed migrations failed as well, even though they didn't run.
+		return exitMigrationFailed
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommand(t *testing.T) {
+	t.Run("success: parses commands and versions", func(t *testing.T) {
+		tests := map[string]struct {
+			args     []string
+			expected command
+		}{
+			"up to latest":  {args: []string{"up"}, expected: command{name: commandUp, version: migrate.Latest}},
+			"up to version": {args: []string{"up", "3"}, expected: command{name: commandUp, version: 3}},
+			"down to zero":  {args: []string{"down", "0"}, expected: command{name: commandDown, version: 0}},
+			"status":        {args: []string{"status"}, expected: command{name: commandStatus}},
+			"goto":          {args: []string{"goto", "2"}, expected: command{name: commandGoto, version: 2}},
+			"redo":          {args: []string{"redo"}, expected: command{name: commandRedo}},
+			"force":         {args: []string{"force", "4"}, expected: command{name: commandForce, version: 4}},
+			"create":        {args: []string{"create", "add", "users"}, expected: command{name: commandCreate, migrationName: "add users"}},
+		}
+
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				cmd, err := parseCommand(tt.args)
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, cmd)
+			})
+		}
+	})
+
+	t.Run("error: invalid arguments", func(t *testing.T) {
+		tests := map[string][]string{
+			"missing command":  nil,
+			"unknown command":  {"sideways"},
+			"missing version":  {"down"},
+			"invalid version":  {"goto", "latest"},
+			"negative version": {"force", "-1"},
+			"extra arguments":  {"status", "now"},
+			"missing name":     {"create"},
+		}
+
+		for name, args := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := parseCommand(args)
+				require.ErrorIs(t, err, errUsage)
+			})
+		}
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("error: missing dsn", func(t *testing.T) {
+		t.Setenv("CODEMIGRATE_DSN", "")
+		t.Setenv("CODEMIGRATE_ENV", "")
+
+		var stdout, stderr bytes.Buffer
+
+		code := run(t.Context(), []string{"status"}, &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+		require.Contains(t, stderr.String(), "missing -dsn")
+	})
+
+	t.Run("error: unknown adapter reported as JSON", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run(t.Context(), []string{"-dsn", "postgres://localhost", "-adapter", "mysql", "-json", "up"}, &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+
+		var res result
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+		require.Equal(t, exitUsage, res.Error.ExitCode)
+		require.Contains(t, res.Error.Message, `unknown adapter "mysql"`)
+	})
+
+	t.Run("error: invalid flag", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run(t.Context(), []string{"-unknown"}, &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+	})
+}
+
+func TestRun_Create(t *testing.T) {
+	t.Run("success: creates scripts without a dsn", func(t *testing.T) {
+		t.Setenv("CODEMIGRATE_DSN", "")
+
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "0001_create_users.up.sql"), nil, 0o644))
+
+		var stdout, stderr bytes.Buffer
+
+		code := run(t.Context(), []string{"-dir", dir, "create", "add", "orders"}, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Equal(t, fmt.Sprintf("created %[1]s\ncreated %[2]s\n",
+			filepath.Join(dir, "0002_add_orders.up.sql"),
+			filepath.Join(dir, "0002_add_orders.down.sql"),
+		), stdout.String())
+	})
+
+	t.Run("success: creates a Go migration for the adapter", func(t *testing.T) {
+		dir := t.TempDir()
+
+		var stdout, stderr bytes.Buffer
+
+		code := run(t.Context(), []string{"-dir", dir, "-adapter", "pq", "-format", "go", "-scheme", "timestamp", "-json", "create", "init"}, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+
+		var res result
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+		require.Len(t, res.Created.Files, 1)
+
+		content, err := os.ReadFile(res.Created.Files[0])
+		require.NoError(t, err)
+		require.Contains(t, string(content), fmt.Sprintf("func (m *migration_%d) Version() int64 {\n\treturn %[1]d\n}", res.Created.Version))
+		require.Contains(t, string(content), "tx *adapter.Versioner[*sql.Tx]")
+	})
+
+	t.Run("error: unknown format", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run(t.Context(), []string{"-dir", t.TempDir(), "-format", "yaml", "create", "init"}, &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+		require.Contains(t, stderr.String(), `unknown format "yaml"`)
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	migrationErr := &migrate.MigrationError{Version: 3, Direction: migrate.DirectionUp, Phase: migrate.PhaseMigration, Err: errors.New("boom")}
+
+	tests := map[string]struct {
+		err      error
+		expected int
+	}{
+		"success":          {err: nil, expected: exitOK},
+		"usage":            {err: fmt.Errorf("%w: missing command", errUsage), expected: exitUsage},
+		"migration failed": {err: fmt.Errorf("upgrade failed: %w", migrationErr), expected: exitMigrationFailed},
+		"irreversible":     {err: fmt.Errorf("rollback failed: %w", &migrate.IrreversibleError{Version: 2}), expected: exitMigrationFailed},
+		"out of order":     {err: fmt.Errorf("upgrade failed: %w", &migrate.OutOfOrderError{Versions: []int64{1}}), expected: exitMigrationFailed},
+		"vetoed":           {err: fmt.Errorf("upgrade failed: %w: version 2", migrate.ErrVetoed), expected: exitMigrationFailed},
+		"dirty":            {err: &migrate.DirtyError{Version: 2}, expected: exitDirty},
+		"lock timeout":     {err: fmt.Errorf("acquiring lock: %w", migrate.ErrLockTimeout), expected: exitLockTimeout},
+		"other":            {err: errors.New("connection refused"), expected: exitError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, exitCode(tt.err))
+		})
+	}
+}
+
+func TestOutput(t *testing.T) {
+	startedAt := time.Date(2025, 1, 3, 10, 0, 0, 0, time.UTC)
+	migrationErr := &migrate.MigrationError{
+		Version:   20250103,
+		Direction: migrate.DirectionUp,
+		Phase:     migrate.PhaseMigration,
+		SQLState:  "42703",
+		Err:       errors.New(`column "nme" does not exist`),
+	}
+
+	report := &migrate.Report{
+		Direction:      migrate.DirectionUp,
+		TargetVersion:  20250104,
+		InitialVersion: 20250101,
+		FinalVersion:   20250102,
+		StartedAt:      startedAt,
+		Duration:       2 * time.Second,
+		Migrations: []migrate.MigrationReport{
+			{Version: 20250102, Direction: migrate.DirectionUp, StartedAt: startedAt, Duration: time.Second, Outcome: migrate.OutcomeApplied},
+			{Version: 20250103, Direction: migrate.DirectionUp, StartedAt: startedAt, Duration: time.Second, Outcome: migrate.OutcomeFailed, Err: migrationErr},
+		},
+		Pending: []migrate.PlannedMigration{
+			{Version: 20250104, Direction: migrate.DirectionUp, NextVersion: 20250104},
+		},
+	}
+
+	t.Run("success: JSON carries the partial report and the failure", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		out := &output{stdout: &stdout, stderr: &stderr, json: true}
+
+		out.failWith(&result{Command: commandUp, Report: newReportOutput(report)}, fmt.Errorf("upgrade failed: %w", migrationErr))
+
+		var res result
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+		require.Equal(t, commandUp, res.Command)
+		require.EqualValues(t, 20250102, res.Report.FinalVersion)
+		require.Len(t, res.Report.Migrations, 2)
+		require.Equal(t, migrate.OutcomeFailed, res.Report.Migrations[1].Outcome)
+		require.Equal(t, []int64{20250104}, res.Report.Pending)
+		require.Equal(t, exitMigrationFailed, res.Error.ExitCode)
+		require.EqualValues(t, 20250103, *res.Error.Version)
+		require.Equal(t, migrate.PhaseMigration, res.Error.Phase)
+		require.Equal(t, "42703", res.Error.SQLState)
+	})
+
+	t.Run("success: text lists the migrations", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		out := &output{stdout: &stdout, stderr: &stderr}
+
+		out.failWith(&result{Command: commandUp, Report: newReportOutput(report)}, migrationErr)
+		require.Contains(t, stdout.String(), "applied 20250102 (1000ms)\n")
+		require.Contains(t, stdout.String(), "failed 20250103: ")
+		require.Contains(t, stdout.String(), "version 20250102\n")
+		require.Contains(t, stderr.String(), "error: up of migration 20250103 failed in migration body")
+	})
+
+	t.Run("success: status", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		out := &output{stdout: &stdout, stderr: &stderr, json: true}
+
+		out.print(&result{Command: commandStatus, Status: newStatusOutput(&migrate.Status{CurrentVersion: 1, Applied: []int64{1}})})
+		require.JSONEq(t, `{
+			"command": "status",
+			"status": {"current_version": 1, "applied": [1], "pending": [], "unknown": [], "dirty": false}
+		}`, stdout.String())
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+type (
+	// output prints results as text or JSON.
+	output struct {
+		stdout io.Writer
+		stderr io.Writer
+		json   bool
+	}
+
+	// result is the outcome of a command, printed by output.
+	result struct {
+		Command string         `json:"command"`
+		Report  *reportOutput  `json:"report,omitempty"`
+		Status  *statusOutput  `json:"status,omitempty"`
+		Created *createdOutput `json:"created,omitempty"`
+		Error   *errorOutput   `json:"error,omitempty"`
+	}
+
+	// createdOutput lists the files of the migration created by the create command.
+	createdOutput struct {
+		Version int64    `json:"version"`
+		Files   []string `json:"files"`
+	}
+
+	// reportOutput is the JSON form of migrate.Report.
+	reportOutput struct {
+		Direction      migrate.Direction `json:"direction"`
+		TargetVersion  int64             `json:"target_version"`
+		InitialVersion int64             `json:"initial_version"`
+		FinalVersion   int64             `json:"final_version"`
+		StartedAt      time.Time         `json:"started_at"`
+		DurationMs     int64             `json:"duration_ms"`
+		Migrations     []migrationOutput `json:"migrations"`
+		Pending        []int64           `json:"pending"`
+	}
+
+	// migrationOutput is the JSON form of migrate.MigrationReport.
+	migrationOutput struct {
+		Version    int64             `json:"version"`
+		Direction  migrate.Direction `json:"direction"`
+		StartedAt  time.Time         `json:"started_at"`
+		DurationMs int64             `json:"duration_ms"`
+		Outcome    migrate.Outcome   `json:"outcome"`
+		Error      string            `json:"error,omitempty"`
+	}
+
+	// statusOutput is the JSON form of migrate.Status.
+	statusOutput struct {
+		CurrentVersion int64   `json:"current_version"`
+		Applied        []int64 `json:"applied"`
+		Pending        []int64 `json:"pending"`
+		Unknown        []int64 `json:"unknown"`
+		Dirty          bool    `json:"dirty"`
+		DirtyVersion   int64   `json:"dirty_version,omitempty"`
+	}
+
+	// errorOutput describes the error of a command, with the details of a failed migration, if any.
+	errorOutput struct {
+		Message   string            `json:"message"`
+		ExitCode  int               `json:"exit_code"`
+		Version   *int64            `json:"version,omitempty"`
+		Direction migrate.Direction `json:"direction,omitempty"`
+		Phase     migrate.Phase     `json:"phase,omitempty"`
+		SQLState  string            `json:"sqlstate,omitempty"`
+	}
+)
+
+func newReportOutput(report *migrate.Report) *reportOutput {
+	out := &reportOutput{
+		Direction:      report.Direction,
+		TargetVersion:  report.TargetVersion,
+		InitialVersion: report.InitialVersion,
+		FinalVersion:   report.FinalVersion,
+		StartedAt:      report.StartedAt,
+		DurationMs:     report.Duration.Milliseconds(),
+		Migrations:     make([]migrationOutput, 0, len(report.Migrations)),
+		Pending:        make([]int64, 0, len(report.Pending)),
+	}
+
+	for _, migration := range report.Migrations {
+		migrationOut := migrationOutput{
+			Version:    migration.Version,
+			Direction:  migration.Direction,
+			StartedAt:  migration.StartedAt,
+			DurationMs: migration.Duration.Milliseconds(),
+			Outcome:    migration.Outcome,
+		}
+		if migration.Err != nil {
+			migrationOut.Error = migration.Err.Error()
+		}
+		out.Migrations = append(out.Migrations, migrationOut)
+	}
+
+	for _, pending := range report.Pending {
+		out.Pending = append(out.Pending, pending.Version)
+	}
+
+	return out
+}
+
+func newStatusOutput(status *migrate.Status) *statusOutput {
+	return &statusOutput{
+		CurrentVersion: status.CurrentVersion,
+		Applied:        nonNil(status.Applied),
+		Pending:        nonNil(status.Pending),
+		Unknown:        nonNil(status.Unknown),
+		Dirty:          status.Dirty,
+		DirtyVersion:   status.DirtyVersion,
+	}
+}
+
+func newErrorOutput(err error) *errorOutput {
+	out := &errorOutput{
+		Message:  err.Error(),
+		ExitCode: exitCode(err),
+	}
+
+	var migrationErr *migrate.MigrationError
+	if errors.As(err, &migrationErr) {
+		out.Version = &migrationErr.Version
+		out.Direction = migrationErr.Direction
+		out.Phase = migrationErr.Phase
+		out.SQLState = migrationErr.SQLState
+	}
+
+	return out
+}
+
+// nonNil returns an empty slice instead of nil, so it's encoded as an empty JSON array.
+func nonNil(versions []int64) []int64 {
+	if versions == nil {
+		return []int64{}
+	}
+	return versions
+}
+
+// print writes the result of a successful command.
+func (o *output) print(res *result) {
+	if o.json {
+		o.writeJSON(res)
+		return
+	}
+
+	if res.Report != nil {
+		o.writeReport(res.Report)
+	}
+
+	if res.Status != nil {
+		o.writeStatus(res.Status)
+	}
+
+	if res.Created != nil {
+		for _, file := range res.Created.Files {
+			fmt.Fprintf(o.stdout, "created %s\n", file)
+		}
+	}
+}
+
+// fail writes the error of a command that didn't run.
+func (o *output) fail(command string, err error) {
+	o.failWith(&result{Command: command}, err)
+}
+
+// failWith writes the error of a command, along with its partial result.
+func (o *output) failWith(res *result, err error) {
+	res.Error = newErrorOutput(err)
+
+	if o.json {
+		o.writeJSON(res)
+		return
+	}
+
+	if res.Report != nil {
+		o.writeReport(res.Report)
+	}
+
+	fmt.Fprintf(o.stderr, "error: %s\n", err)
+}
+
+func (o *output) writeJSON(res *result) {
+	encoder := json.NewEncoder(o.stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(res); err != nil {
+		fmt.Fprintf(o.stderr, "error: encoding result: %s\n", err)
+	}
+}
+
+func (o *output) writeReport(report *reportOutput) {
+	for _, migration := range report.Migrations {
+		if migration.Error != "" {
+			fmt.Fprintf(o.stdout, "%s %d: %s\n", migration.Outcome, migration.Version, migration.Error)
+			continue
+		}
+		fmt.Fprintf(o.stdout, "%s %d (%dms)\n", migration.Outcome, migration.Version, migration.DurationMs)
+	}
+
+	if len(report.Migrations) == 0 {
+		fmt.Fprintln(o.stdout, "no migrations to run")
+	}
+
+	fmt.Fprintf(o.stdout, "version %d\n", report.FinalVersion)
+}
+
+func (o *output) writeStatus(status *statusOutput) {
+	fmt.Fprintf(o.stdout, "version: %d\n", status.CurrentVersion)
+	fmt.Fprintf(o.stdout, "applied: %v\n", status.Applied)
+	fmt.Fprintf(o.stdout, "pending: %v\n", status.Pending)
+
+	if len(status.Unknown) > 0 {
+		fmt.Fprintf(o.stdout, "unknown: %v\n", status.Unknown)
+	}
+
+	if status.Dirty {
+		fmt.Fprintf(o.stdout, "dirty: migration %d was left partially applied\n", status.DirtyVersion)
+	}
+}
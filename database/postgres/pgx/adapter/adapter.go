@@ -5,8 +5,17 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sonalys/codemigrate/migrate"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type (
@@ -15,22 +24,65 @@ type (
 	}
 
 	Config struct {
-		tableName string
+		tableName        string
+		historyTableName string
+		appliedTableName string
+		appName          string
+		hostName         string
+		logger           *slog.Logger
+		tracer           trace.Tracer
+		lockKey          int64
+		lockTimeout      time.Duration
+		lockTableName    string
 	}
 
 	Postgres struct {
 		db     Database
 		config Config
+
+		// lockSlot is filled while a caller holds the lock, so that other callers of this instance wait.
+		lockSlot  chan struct{}
+		lockMutex sync.Mutex
+		lock      *runLock
+
+		// schemaReady is set once the migration tables are created and upgraded.
+		schemaReady atomic.Bool
 	}
 
+	// Versioner runs the migration statements.
+	// Outside a transaction, such as for NonTransactional migrations, statements are committed as they run,
+	// and the methods of the embedded Tx that Versioner doesn't override fail with migrate.ErrNoTransaction.
 	Versioner struct {
 		pgx.Tx
-		config Config
+		config   Config
+		executor executor
+		// missing is set when a read finds parts of the schema that weren't created or upgraded yet.
+		missing missingSchema
+	}
+
+	// executor runs statements, inside or outside a transaction.
+	// It's implemented by pgx.Tx, *pgx.Conn and *pgxpool.Pool.
+	executor interface {
+		Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+		Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+		QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 	}
 
 	Option func(*Config)
 )
 
+var (
+	_ migrate.AppliedVersioner  = (*Versioner)(nil)
+	_ migrate.ChecksumVersioner = (*Versioner)(nil)
+	_ migrate.DirtyVersioner    = (*Versioner)(nil)
+	_ migrate.HistoryVersioner  = (*Versioner)(nil)
+	_ migrate.Lease             = (*Postgres)(nil)
+	_ migrate.Locker            = (*Postgres)(nil)
+
+	_ migrate.NonTransactionalDatabase[*Versioner] = (*Postgres)(nil)
+	_ migrate.ReadOnlyDatabase[*Versioner]         = (*Postgres)(nil)
+)
+
 // WithTableName sets the table name for the schema migrations table.
 func WithTableName(name string) Option {
 	return func(p *Config) {
@@ -38,11 +90,60 @@ func WithTableName(name string) Option {
 	}
 }
 
+// WithHistoryTableName sets the table name for the migration history table.
+// It defaults to the schema migrations table name with the "_history" suffix.
+func WithHistoryTableName(name string) Option {
+	return func(p *Config) {
+		p.historyTableName = name
+	}
+}
+
+// WithAppliedTableName sets the table name for the applied versions table.
+// It defaults to the schema migrations table name with the "_applied" suffix.
+func WithAppliedTableName(name string) Option {
+	return func(p *Config) {
+		p.appliedTableName = name
+	}
+}
+
+// WithLogger sets the logger used to report transactions and version changes.
+// By default, nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Config) {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+		p.logger = logger
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used to trace the versioner queries and script migrations.
+// By default, nothing is traced.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(p *Config) {
+		if provider == nil {
+			provider = noop.NewTracerProvider()
+		}
+		p.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// WithAppName sets the application name recorded in the migration history.
+func WithAppName(name string) Option {
+	return func(p *Config) {
+		p.appName = name
+	}
+}
+
 func From(db Database, opts ...Option) *Postgres {
 	posgtres := &Postgres{
-		db: db,
+		db:       db,
+		lockSlot: make(chan struct{}, 1),
 		config: Config{
 			tableName: "schema_migrations",
+			hostName:  hostName(),
+			logger:    slog.New(slog.DiscardHandler),
+			tracer:    noop.NewTracerProvider().Tracer(tracerName),
 		},
 	}
 
@@ -50,10 +151,60 @@ func From(db Database, opts ...Option) *Postgres {
 		opt(&posgtres.config)
 	}
 
+	if posgtres.config.historyTableName == "" {
+		posgtres.config.historyTableName = posgtres.config.tableName + "_history"
+	}
+
+	if posgtres.config.appliedTableName == "" {
+		posgtres.config.appliedTableName = posgtres.config.tableName + "_applied"
+	}
+
+	if posgtres.config.lockTableName == "" {
+		posgtres.config.lockTableName = posgtres.config.tableName + "_lock"
+	}
+
+	if posgtres.config.lockKey == 0 {
+		posgtres.config.lockKey = lockKey(posgtres.config.tableName)
+	}
+
 	return posgtres
 }
 
+// schemaQueries returns the queries that create the tables used to keep track of the migrations.
+func (c Config) schemaQueries() []string {
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, dirty_version BIGINT)", c.tableName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			version BIGINT NOT NULL,
+			direction TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			host TEXT NOT NULL,
+			app TEXT NOT NULL
+		)`, c.historyTableName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			version BIGINT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			checksum TEXT
+		)`, c.appliedTableName),
+	}
+}
+
+// Transaction calls the handler in a transaction that changes the migration state.
+// Unless Lock is held, it waits for the advisory lock, released when the transaction ends.
 func (p *Postgres) Transaction(ctx context.Context, handler func(tx *Versioner) error) error {
+	return p.transaction(ctx, true, handler)
+}
+
+// ReadTransaction calls the handler in a transaction that only reads the migration state.
+// It doesn't wait for the advisory lock, so it doesn't block while another process migrates.
+func (p *Postgres) ReadTransaction(ctx context.Context, handler func(tx *Versioner) error) error {
+	return p.transaction(ctx, false, handler)
+}
+
+// transaction calls the handler in a transaction. When write is set, it takes the advisory lock, unless Lock holds it.
+func (p *Postgres) transaction(ctx context.Context, write bool, handler func(tx *Versioner) error) error {
 	tx, err := p.db.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -62,27 +213,91 @@ func (p *Postgres) Transaction(ctx context.Context, handler func(tx *Versioner)
 		_ = tx.Rollback(ctx)
 	}()
 
-	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY)", p.config.tableName)
+	p.config.logger.DebugContext(ctx, "transaction started")
 
-	if _, err = tx.Exec(ctx, query); err != nil {
-		return fmt.Errorf("failed to create %s table: %w", p.config.tableName, err)
+	if write && !p.isLocked() {
+		if err := p.lockTransaction(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	missing, err := p.prepareSchema(ctx, tx, write)
+	if err != nil {
+		return err
 	}
 
 	versioner := &Versioner{
-		Tx:     tx,
-		config: p.config,
+		Tx:       tx,
+		config:   p.config,
+		executor: tx,
+		missing:  missing,
 	}
 
 	if err := handler(versioner); err != nil {
+		p.config.logger.DebugContext(ctx, "transaction rolled back", "error", err)
 		return fmt.Errorf("handler error: %w", err)
 	}
 
-	return tx.Commit(ctx)
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if missing == (missingSchema{}) {
+		p.schemaReady.Store(true)
+	}
+
+	p.config.logger.DebugContext(ctx, "transaction committed")
+	return nil
+}
+
+// WithoutTransaction calls the handler with a versioner that runs statements directly on the database.
+// Each statement is committed as it runs. It's used by NonTransactional migrations.
+// The database must run statements by itself, such as *pgx.Conn or *pgxpool.Pool.
+func (p *Postgres) WithoutTransaction(ctx context.Context, handler func(tx *Versioner) error) error {
+	db, ok := p.db.(executor)
+	if !ok {
+		return fmt.Errorf("%w: %T can't run statements", migrate.ErrNoTransactionNotSupported, p.db)
+	}
+
+	p.config.logger.DebugContext(ctx, "running without transaction")
+
+	versioner := &Versioner{
+		Tx:       detachedTx{err: migrate.ErrNoTransaction},
+		config:   p.config,
+		executor: db,
+	}
+
+	if err := handler(versioner); err != nil {
+		return fmt.Errorf("handler error: %w", err)
+	}
+	return nil
+}
+
+// Exec runs the statement in the transaction, or directly on the database outside a transaction.
+func (p *Versioner) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return p.executor.Exec(ctx, sql, args...)
 }
 
-func (p *Versioner) GetCurrentVersion(ctx context.Context) (int64, error) {
+// Query runs the query in the transaction, or directly on the database outside a transaction.
+func (p *Versioner) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return p.executor.Query(ctx, sql, args...)
+}
+
+// QueryRow runs the query in the transaction, or directly on the database outside a transaction.
+func (p *Versioner) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return p.executor.QueryRow(ctx, sql, args...)
+}
+
+func (p *Versioner) GetCurrentVersion(ctx context.Context) (_ int64, err error) {
 	query := fmt.Sprintf("SELECT version FROM %s", p.config.tableName)
 
+	ctx, span := p.config.startSpan(ctx, "GetCurrentVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.versionTable {
+		return 0, nil
+	}
+
 	row, err := p.Query(ctx, query)
 	if err != nil {
 		return 0, fmt.Errorf("failed to query %s: %w", p.config.tableName, err)
@@ -98,12 +313,17 @@ func (p *Versioner) GetCurrentVersion(ctx context.Context) (int64, error) {
 	if err := row.Scan(&version); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return 0, fmt.Errorf("failed to scan version: %w", err)
 	}
+
+	p.config.logger.DebugContext(ctx, "read current version", "version", version)
 	return version, nil
 }
 
-func (p *Versioner) SetVersion(ctx context.Context, version int64) error {
+func (p *Versioner) SetVersion(ctx context.Context, version int64) (err error) {
 	query := fmt.Sprintf("UPDATE %s SET version = $1", p.config.tableName)
 
+	ctx, span := p.config.startSpan(ctx, "SetVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
 	if cmd, err := p.Exec(ctx, query, version); err != nil {
 		return fmt.Errorf("failed to update version: %w", err)
 	} else if cmd.RowsAffected() == 0 {
@@ -113,5 +333,246 @@ func (p *Versioner) SetVersion(ctx context.Context, version int64) error {
 			return fmt.Errorf("failed to insert default version: %w", err)
 		}
 	}
+
+	p.config.logger.DebugContext(ctx, "set version", "version", version)
+	return nil
+}
+
+func (p *Versioner) RecordHistory(ctx context.Context, entry migrate.HistoryEntry) (err error) {
+	query := fmt.Sprintf(
+		"INSERT INTO %s (version, direction, applied_at, duration_ms, host, app) VALUES ($1, $2, $3, $4, $5, $6)",
+		p.config.historyTableName,
+	)
+
+	ctx, span := p.config.startSpan(ctx, "RecordHistory", p.config.historyTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.Exec(ctx, query,
+		entry.Version,
+		string(entry.Direction),
+		entry.AppliedAt,
+		entry.Duration.Milliseconds(),
+		p.config.hostName,
+		p.config.appName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert history entry: %w", err)
+	}
+	return nil
+}
+
+func (p *Versioner) GetHistory(ctx context.Context) (_ []migrate.HistoryEntry, err error) {
+	query := fmt.Sprintf(
+		"SELECT version, direction, applied_at, duration_ms, host, app FROM %s ORDER BY id",
+		p.config.historyTableName,
+	)
+
+	ctx, span := p.config.startSpan(ctx, "GetHistory", p.config.historyTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.historyTable {
+		return nil, nil
+	}
+
+	rows, err := p.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", p.config.historyTableName, err)
+	}
+	defer rows.Close()
+
+	var history []migrate.HistoryEntry
+
+	for rows.Next() {
+		var (
+			entry      migrate.HistoryEntry
+			direction  string
+			durationMs int64
+		)
+
+		if err := rows.Scan(&entry.Version, &direction, &entry.AppliedAt, &durationMs, &entry.Host, &entry.App); err != nil {
+			return nil, fmt.Errorf("failed to scan history entry: %w", err)
+		}
+
+		entry.Direction = migrate.Direction(direction)
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return history, nil
+}
+
+func (p *Versioner) GetAppliedVersions(ctx context.Context) (_ []int64, err error) {
+	query := fmt.Sprintf("SELECT version FROM %s", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "GetAppliedVersions", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.appliedTable {
+		return nil, nil
+	}
+
+	rows, err := p.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", p.config.appliedTableName, err)
+	}
+	defer rows.Close()
+
+	var versions []int64
+
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan applied version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (p *Versioner) MarkApplied(ctx context.Context, version int64) (err error) {
+	query := fmt.Sprintf("INSERT INTO %s (version) VALUES ($1) ON CONFLICT (version) DO NOTHING", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "MarkApplied", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.Exec(ctx, query, version); err != nil {
+		return fmt.Errorf("failed to insert applied version: %w", err)
+	}
+	return nil
+}
+
+func (p *Versioner) MarkReverted(ctx context.Context, version int64) (err error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE version = $1", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "MarkReverted", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.Exec(ctx, query, version); err != nil {
+		return fmt.Errorf("failed to delete applied version: %w", err)
+	}
+	return nil
+}
+
+func (p *Versioner) GetChecksums(ctx context.Context) (_ map[int64]string, err error) {
+	query := fmt.Sprintf("SELECT version, checksum FROM %s WHERE checksum IS NOT NULL", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "GetChecksums", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.appliedTable {
+		return map[int64]string{}, nil
+	}
+
+	rows, err := p.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", p.config.appliedTableName, err)
+	}
+	defer rows.Close()
+
+	checksums := make(map[int64]string)
+
+	for rows.Next() {
+		var (
+			version  int64
+			checksum string
+		)
+
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, fmt.Errorf("failed to scan checksum: %w", err)
+		}
+		checksums[version] = checksum
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksums: %w", err)
+	}
+	return checksums, nil
+}
+
+func (p *Versioner) SetChecksum(ctx context.Context, version int64, checksum string) (err error) {
+	query := fmt.Sprintf(
+		"INSERT INTO %s (version, checksum) VALUES ($1, $2) ON CONFLICT (version) DO UPDATE SET checksum = EXCLUDED.checksum",
+		p.config.appliedTableName,
+	)
+
+	ctx, span := p.config.startSpan(ctx, "SetChecksum", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.Exec(ctx, query, version, checksum); err != nil {
+		return fmt.Errorf("failed to store checksum: %w", err)
+	}
 	return nil
 }
+
+func (p *Versioner) GetDirtyVersion(ctx context.Context) (_ int64, _ bool, err error) {
+	query := fmt.Sprintf("SELECT dirty_version FROM %s", p.config.tableName)
+
+	ctx, span := p.config.startSpan(ctx, "GetDirtyVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.dirtyColumn {
+		return 0, false, nil
+	}
+
+	var version *int64
+
+	if err := p.QueryRow(ctx, query).Scan(&version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to scan dirty version: %w", err)
+	}
+
+	if version == nil {
+		return 0, false, nil
+	}
+	return *version, true, nil
+}
+
+func (p *Versioner) SetDirtyVersion(ctx context.Context, version int64) (err error) {
+	query := fmt.Sprintf("UPDATE %s SET dirty_version = $1", p.config.tableName)
+
+	ctx, span := p.config.startSpan(ctx, "SetDirtyVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if cmd, err := p.Exec(ctx, query, version); err != nil {
+		return fmt.Errorf("failed to update dirty version: %w", err)
+	} else if cmd.RowsAffected() == 0 {
+		query = fmt.Sprintf("INSERT INTO %s (version, dirty_version) VALUES (0, $1)", p.config.tableName)
+		if _, err := p.Exec(ctx, query, version); err != nil {
+			return fmt.Errorf("failed to insert dirty version: %w", err)
+		}
+	}
+
+	p.config.logger.DebugContext(ctx, "set dirty version", "version", version)
+	return nil
+}
+
+func (p *Versioner) ClearDirtyVersion(ctx context.Context) (err error) {
+	query := fmt.Sprintf("UPDATE %s SET dirty_version = NULL", p.config.tableName)
+
+	ctx, span := p.config.startSpan(ctx, "ClearDirtyVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to clear dirty version: %w", err)
+	}
+
+	p.config.logger.DebugContext(ctx, "cleared dirty version")
+	return nil
+}
+
+func hostName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
@@ -1,15 +1,25 @@
 package adapter_test
 
 import (
+	"context"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/sonalys/codemigrate/database/postgres/pgx/adapter"
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/sonalys/codemigrate/migrate/sqlscript"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func TestPostgres_Transaction(t *testing.T) {
+func newConnection(t *testing.T) *pgx.Conn {
 	ctx := t.Context()
 
 	pgContainer, err := postgres.Run(ctx, "postgres:16",
@@ -19,24 +29,31 @@ func TestPostgres_Transaction(t *testing.T) {
 		postgres.BasicWaitStrategies(),
 	)
 	require.NoError(t, err)
-	defer func() {
+	t.Cleanup(func() {
 		err := pgContainer.Terminate(ctx)
 		require.NoError(t, err)
-	}()
+	})
 
 	connStr, err := pgContainer.ConnectionString(ctx)
 	require.NoError(t, err)
 
 	conn, err := pgx.Connect(ctx, connStr)
 	require.NoError(t, err)
-	defer func() {
+	t.Cleanup(func() {
 		err := conn.Close(ctx)
 		require.NoError(t, err)
-	}()
+	})
+
+	return conn
+}
+
+func TestPostgres_Transaction(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
 
 	pg := adapter.From(conn, adapter.WithTableName("test_migrations"))
 
-	err = pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+	err := pg.Transaction(ctx, func(tx *adapter.Versioner) error {
 		version, err := tx.GetCurrentVersion(ctx)
 		require.NoError(t, err)
 		require.EqualValues(t, 0, version)
@@ -52,3 +69,624 @@ func TestPostgres_Transaction(t *testing.T) {
 	})
 	require.NoError(t, err)
 }
+
+func TestPostgres_History(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	pg := adapter.From(conn, adapter.WithAppName("test-app"))
+
+	err := pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+		history, err := tx.GetHistory(ctx)
+		require.NoError(t, err)
+		require.Empty(t, history)
+
+		err = tx.RecordHistory(ctx, migrate.HistoryEntry{
+			Version:   1,
+			Direction: migrate.DirectionUp,
+			AppliedAt: time.Now(),
+			Duration:  time.Second,
+		})
+		require.NoError(t, err)
+
+		err = tx.RecordHistory(ctx, migrate.HistoryEntry{
+			Version:   1,
+			Direction: migrate.DirectionDown,
+			AppliedAt: time.Now(),
+			Duration:  2 * time.Second,
+		})
+		require.NoError(t, err)
+
+		history, err = tx.GetHistory(ctx)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, migrate.DirectionUp, history[0].Direction)
+		require.Equal(t, time.Second, history[0].Duration)
+		require.Equal(t, "test-app", history[0].App)
+		require.Equal(t, migrate.DirectionDown, history[1].Direction)
+
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPostgres_AppliedVersions(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	pg := adapter.From(conn)
+
+	err := pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+		versions, err := tx.GetAppliedVersions(ctx)
+		require.NoError(t, err)
+		require.Empty(t, versions)
+
+		require.NoError(t, tx.MarkApplied(ctx, 20250101))
+		require.NoError(t, tx.MarkApplied(ctx, 20241231))
+		require.NoError(t, tx.MarkApplied(ctx, 20241231))
+
+		versions, err = tx.GetAppliedVersions(ctx)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []int64{20241231, 20250101}, versions)
+
+		require.NoError(t, tx.MarkReverted(ctx, 20250101))
+
+		versions, err = tx.GetAppliedVersions(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []int64{20241231}, versions)
+
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPostgres_Checksums(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	pg := adapter.From(conn)
+
+	err := pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+		require.NoError(t, tx.MarkApplied(ctx, 1))
+		require.NoError(t, tx.SetChecksum(ctx, 2, "abc"))
+
+		checksums, err := tx.GetChecksums(ctx)
+		require.NoError(t, err)
+		require.Equal(t, map[int64]string{2: "abc"}, checksums)
+
+		require.NoError(t, tx.SetChecksum(ctx, 2, "def"))
+
+		checksums, err = tx.GetChecksums(ctx)
+		require.NoError(t, err)
+		require.Equal(t, map[int64]string{2: "def"}, checksums)
+
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPostgres_Tracing(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	pg := adapter.From(conn, adapter.WithTracerProvider(provider))
+
+	migration, err := adapter.NewScriptMigrationFromReader(1,
+		strings.NewReader("CREATE TABLE traced (id INT)"),
+		strings.NewReader("DROP TABLE traced"),
+	)
+	require.NoError(t, err)
+
+	err = pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+		if err := migration.Up(ctx, tx); err != nil {
+			return err
+		}
+		return tx.SetVersion(ctx, 1)
+	})
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	require.Equal(t, "ScriptMigration.Up", spans[0].Name())
+	require.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	require.Contains(t, spans[0].Attributes(), semconv.DBQueryText("CREATE TABLE traced (id INT)"))
+
+	require.Equal(t, "SetVersion", spans[1].Name())
+	require.Contains(t, spans[1].Attributes(), semconv.DBCollectionName("schema_migrations"))
+}
+
+func TestPostgres_Lock(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	otherConn, err := pgx.Connect(ctx, conn.Config().ConnString())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = otherConn.Close(ctx)
+	})
+
+	owner := adapter.From(conn)
+	other := adapter.From(otherConn, adapter.WithLockTimeout(300*time.Millisecond))
+
+	require.NoError(t, owner.Lock(ctx))
+
+	t.Run("error: lock is held by another session", func(t *testing.T) {
+		err := other.Lock(ctx)
+		require.ErrorIs(t, err, migrate.ErrLockTimeout)
+	})
+
+	t.Run("error: callers of the same instance wait for the lock", func(t *testing.T) {
+		waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+
+		err := owner.Lock(waitCtx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("error: transactions wait for the lock", func(t *testing.T) {
+		err := other.Transaction(ctx, func(tx *adapter.Versioner) error {
+			return nil
+		})
+		require.ErrorIs(t, err, migrate.ErrLockTimeout)
+	})
+
+	t.Run("success: read transactions don't wait for the lock", func(t *testing.T) {
+		err := other.ReadTransaction(ctx, func(tx *adapter.Versioner) error {
+			_, err := tx.GetCurrentVersion(ctx)
+			return err
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("success: lock owner runs transactions", func(t *testing.T) {
+		err := owner.Transaction(ctx, func(tx *adapter.Versioner) error {
+			return tx.SetVersion(ctx, 1)
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("success: lock is acquired after release", func(t *testing.T) {
+		require.NoError(t, owner.Unlock(ctx))
+		require.NoError(t, other.Lock(ctx))
+		require.NoError(t, other.Unlock(ctx))
+	})
+
+	t.Run("success: different keys don't conflict", func(t *testing.T) {
+		require.NoError(t, owner.Lock(ctx))
+		t.Cleanup(func() { _ = owner.Unlock(ctx) })
+
+		unrelated := adapter.From(otherConn, adapter.WithLockKey(42), adapter.WithLockTimeout(300*time.Millisecond))
+		require.NoError(t, unrelated.Lock(ctx))
+		require.NoError(t, unrelated.Unlock(ctx))
+	})
+}
+
+func TestPostgres_Lease(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	pg := adapter.From(conn)
+
+	t.Run("success: lease is held by a single owner", func(t *testing.T) {
+		acquired, err := pg.AcquireLease(ctx, "a", time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		acquired, err = pg.AcquireLease(ctx, "b", time.Minute)
+		require.NoError(t, err)
+		require.False(t, acquired)
+
+		renewed, err := pg.RenewLease(ctx, "a", time.Minute)
+		require.NoError(t, err)
+		require.True(t, renewed)
+
+		renewed, err = pg.RenewLease(ctx, "b", time.Minute)
+		require.NoError(t, err)
+		require.False(t, renewed)
+
+		require.NoError(t, pg.ReleaseLease(ctx, "a"))
+
+		acquired, err = pg.AcquireLease(ctx, "b", time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+		require.NoError(t, pg.ReleaseLease(ctx, "b"))
+	})
+
+	t.Run("success: expired lease is taken over", func(t *testing.T) {
+		acquired, err := pg.AcquireLease(ctx, "crashed", time.Millisecond)
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		time.Sleep(10 * time.Millisecond)
+
+		locker, err := migrate.NewLeaseLocker(pg, time.Second)
+		require.NoError(t, err)
+		require.NoError(t, locker.Lock(ctx))
+		require.NoError(t, locker.Unlock(ctx))
+	})
+}
+
+func TestPostgres_DirtyVersion(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	pg := adapter.From(conn)
+
+	err := pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+		_, dirty, err := tx.GetDirtyVersion(ctx)
+		require.NoError(t, err)
+		require.False(t, dirty)
+
+		require.NoError(t, tx.SetDirtyVersion(ctx, 3))
+
+		version, dirty, err := tx.GetDirtyVersion(ctx)
+		require.NoError(t, err)
+		require.True(t, dirty)
+		require.EqualValues(t, 3, version)
+
+		current, err := tx.GetCurrentVersion(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, current)
+
+		require.NoError(t, tx.ClearDirtyVersion(ctx))
+
+		_, dirty, err = tx.GetDirtyVersion(ctx)
+		require.NoError(t, err)
+		require.False(t, dirty)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPostgres_SchemaUpgrade(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	t.Run("success: reads don't create the migration tables", func(t *testing.T) {
+		err := adapter.From(conn).ReadTransaction(ctx, func(tx *adapter.Versioner) error {
+			version, err := tx.GetCurrentVersion(ctx)
+			require.NoError(t, err)
+			require.Zero(t, version)
+
+			history, err := tx.GetHistory(ctx)
+			require.NoError(t, err)
+			require.Empty(t, history)
+
+			applied, err := tx.GetAppliedVersions(ctx)
+			require.NoError(t, err)
+			require.Empty(t, applied)
+			return nil
+		})
+		require.NoError(t, err)
+
+		var exists bool
+		require.NoError(t, conn.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists))
+		require.False(t, exists)
+	})
+
+	// Version tables created by older releases don't have the dirty version.
+	_, err := conn.Exec(ctx, "CREATE TABLE schema_migrations (version BIGINT PRIMARY KEY)")
+	require.NoError(t, err)
+
+	hasDirtyColumn := func() bool {
+		var exists bool
+		err := conn.QueryRow(ctx, `SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'schema_migrations' AND column_name = 'dirty_version'
+		)`).Scan(&exists)
+		require.NoError(t, err)
+		return exists
+	}
+
+	pg := adapter.From(conn)
+
+	t.Run("success: reads don't upgrade the version table", func(t *testing.T) {
+		err := pg.ReadTransaction(ctx, func(tx *adapter.Versioner) error {
+			_, dirty, err := tx.GetDirtyVersion(ctx)
+			require.NoError(t, err)
+			require.False(t, dirty)
+			return nil
+		})
+		require.NoError(t, err)
+		require.False(t, hasDirtyColumn())
+	})
+
+	t.Run("success: writes upgrade the version table", func(t *testing.T) {
+		err := pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+			return tx.SetDirtyVersion(ctx, 1)
+		})
+		require.NoError(t, err)
+		require.True(t, hasDirtyColumn())
+
+		err = pg.ReadTransaction(ctx, func(tx *adapter.Versioner) error {
+			version, dirty, err := tx.GetDirtyVersion(ctx)
+			require.NoError(t, err)
+			require.True(t, dirty)
+			require.EqualValues(t, 1, version)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestPostgres_WithoutTransaction(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	pg := adapter.From(conn)
+
+	createTable, err := adapter.NewScriptMigrationFromReader(1,
+		strings.NewReader("CREATE TABLE users (id INT, email TEXT)"),
+		strings.NewReader("DROP TABLE users"),
+	)
+	require.NoError(t, err)
+
+	createIndex, err := adapter.NewScriptMigrationFromReader(2,
+		strings.NewReader("-- +codemigrate NoTransaction\nCREATE INDEX CONCURRENTLY users_email ON users (email)"),
+		strings.NewReader("-- +codemigrate NoTransaction\nDROP INDEX CONCURRENTLY users_email"),
+	)
+	require.NoError(t, err)
+	require.True(t, createIndex.NoTransaction())
+
+	migrator, err := migrate.New(pg, createTable, createIndex)
+	require.NoError(t, err)
+
+	require.NoError(t, migrator.Up(ctx, migrate.Latest))
+
+	err = pg.Transaction(ctx, func(tx *adapter.Versioner) error {
+		version, err := tx.GetCurrentVersion(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, version)
+
+		_, dirty, err := tx.GetDirtyVersion(ctx)
+		require.NoError(t, err)
+		require.False(t, dirty)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, migrator.Down(ctx, migrate.Zero))
+}
+
+func TestScriptMigration_Irreversible(t *testing.T) {
+	t.Run("success: migration without down script is irreversible", func(t *testing.T) {
+		migration, err := adapter.NewScriptMigrationFromReader(1, strings.NewReader("DROP TABLE legacy"), nil)
+		require.NoError(t, err)
+		require.True(t, migration.Irreversible())
+
+		fileSystem := fstest.MapFS{
+			"0001_drop.up.sql": {Data: []byte("DROP TABLE legacy")},
+		}
+
+		migration, err = adapter.NewScriptMigrationFromFile(1, fileSystem, "0001_drop.up.sql", "")
+		require.NoError(t, err)
+		require.True(t, migration.Irreversible())
+	})
+
+	t.Run("success: migration with down script is reversible", func(t *testing.T) {
+		migration, err := adapter.NewScriptMigrationFromReader(1,
+			strings.NewReader("CREATE TABLE users (id INT)"),
+			strings.NewReader("DROP TABLE users"),
+		)
+		require.NoError(t, err)
+		require.False(t, migration.Irreversible())
+	})
+}
+
+func TestScriptMigration_Checksum(t *testing.T) {
+	newMigration := func(t *testing.T, up, down string) string {
+		t.Helper()
+		migration, err := adapter.NewScriptMigrationFromReader(1, strings.NewReader(up), strings.NewReader(down))
+		require.NoError(t, err)
+		return migration.Checksum()
+	}
+
+	t.Run("success: identical scripts have the same checksum", func(t *testing.T) {
+		require.Equal(t,
+			newMigration(t, "CREATE TABLE users (id INT)", "DROP TABLE users"),
+			newMigration(t, "CREATE TABLE users (id INT)", "DROP TABLE users"),
+		)
+	})
+
+	t.Run("success: editing the down script changes the checksum", func(t *testing.T) {
+		require.NotEqual(t,
+			newMigration(t, "CREATE TABLE users (id INT)", "DROP TABLE users"),
+			newMigration(t, "CREATE TABLE users (id INT)", "DROP TABLE IF EXISTS users"),
+		)
+	})
+
+	t.Run("success: adding a directive changes the checksum", func(t *testing.T) {
+		require.NotEqual(t,
+			newMigration(t, "CREATE INDEX users_id ON users (id)", ""),
+			newMigration(t, "-- +codemigrate NoTransaction\nCREATE INDEX users_id ON users (id)", ""),
+		)
+	})
+
+	t.Run("success: moving a statement between the scripts changes the checksum", func(t *testing.T) {
+		require.NotEqual(t,
+			newMigration(t, "CREATE TABLE users (id INT)", "DROP TABLE users"),
+			newMigration(t, "CREATE TABLE users (id INT)DROP TABLE users", ""),
+		)
+	})
+}
+
+func TestScriptMigration_Directives(t *testing.T) {
+	ctx := t.Context()
+	pg := adapter.From(nil)
+
+	t.Run("success: single file with both directions", func(t *testing.T) {
+		content := `-- +codemigrate Up
+CREATE TABLE users (id INT);
+-- +codemigrate StatementBegin
+CREATE FUNCTION one() RETURNS INT AS $$ SELECT 1; $$ LANGUAGE SQL;
+-- +codemigrate StatementEnd
+-- +codemigrate Down
+DROP TABLE users;
+`
+		fileSystem := fstest.MapFS{
+			"migrations/0001_create_users.sql": {Data: []byte(content)},
+		}
+
+		migrations, err := adapter.LoadScriptMigrations(fileSystem, "migrations")
+		require.NoError(t, err)
+		require.Len(t, migrations, 1)
+
+		migration := migrations[0].(*adapter.ScriptMigration)
+		require.False(t, migration.Irreversible())
+
+		statements, err := pg.Record(ctx, func(tx *adapter.Versioner) error {
+			return migration.Up(ctx, tx)
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"CREATE TABLE users (id INT);",
+			"CREATE FUNCTION one() RETURNS INT AS $$ SELECT 1; $$ LANGUAGE SQL;",
+		}, statements)
+
+		statements, err = pg.Record(ctx, func(tx *adapter.Versioner) error {
+			return migration.Down(ctx, tx)
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"DROP TABLE users;"}, statements)
+	})
+
+	t.Run("success: no transaction directive", func(t *testing.T) {
+		migration, err := adapter.NewScriptMigrationFromReader(1,
+			strings.NewReader("-- +codemigrate NoTransaction\nCREATE INDEX CONCURRENTLY idx ON users (id)"),
+			nil,
+		)
+		require.NoError(t, err)
+		require.True(t, migration.NoTransaction())
+	})
+
+	t.Run("error: down section defined twice", func(t *testing.T) {
+		_, err := adapter.NewScriptMigrationFromReader(1,
+			strings.NewReader("-- +codemigrate Up\nCREATE TABLE users (id INT);\n-- +codemigrate Down\nDROP TABLE users;"),
+			strings.NewReader("DROP TABLE users;"),
+		)
+		require.ErrorIs(t, err, sqlscript.ErrDirective)
+	})
+
+	t.Run("error: invalid directive", func(t *testing.T) {
+		_, err := adapter.NewScriptMigrationFromReader(1, strings.NewReader("-- +codemigrate Sideways"), nil)
+		require.ErrorIs(t, err, sqlscript.ErrDirective)
+	})
+}
+
+func TestScriptMigration_StatementError(t *testing.T) {
+	ctx := t.Context()
+	conn := newConnection(t)
+
+	pg := adapter.From(conn)
+
+	fileSystem := fstest.MapFS{
+		"migrations/0001_create_users.up.sql": {Data: []byte("CREATE TABLE users (id INT);\n\n-- selects a missing column\nSELECT id,\n  nme FROM users;\n")},
+	}
+
+	migrations, err := adapter.LoadScriptMigrations(fileSystem, "migrations")
+	require.NoError(t, err)
+
+	migrator, err := migrate.New(pg, migrations...)
+	require.NoError(t, err)
+
+	err = migrator.Up(ctx, migrate.Latest)
+
+	var statementErr *sqlscript.StatementError
+	require.ErrorAs(t, err, &statementErr)
+	require.Equal(t, "migrations/0001_create_users.up.sql", statementErr.File)
+	require.Equal(t, 2, statementErr.Statement)
+	require.Equal(t, 5, statementErr.Line)
+	require.Equal(t, 3, statementErr.Column)
+
+	var migrationErr *migrate.MigrationError
+	require.ErrorAs(t, err, &migrationErr)
+	require.EqualValues(t, 1, migrationErr.Version)
+	require.Equal(t, migrate.PhaseMigration, migrationErr.Phase)
+	require.Equal(t, "42703", migrationErr.SQLState)
+}
+
+func TestPostgres_Record(t *testing.T) {
+	ctx := t.Context()
+	pg := adapter.From(nil, adapter.WithAppName("api"))
+
+	t.Run("success: records statements with inlined arguments", func(t *testing.T) {
+		migration, err := adapter.NewScriptMigrationFromReader(2,
+			strings.NewReader("ALTER TABLE users ADD COLUMN email TEXT"),
+			strings.NewReader("ALTER TABLE users DROP COLUMN email"),
+		)
+		require.NoError(t, err)
+
+		statements, err := pg.Record(ctx, func(tx *adapter.Versioner) error {
+			if err := migration.Up(ctx, tx); err != nil {
+				return err
+			}
+			if err := tx.SetChecksum(ctx, 2, "it's"); err != nil {
+				return err
+			}
+			return tx.SetVersion(ctx, 2)
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"ALTER TABLE users ADD COLUMN email TEXT",
+			"INSERT INTO schema_migrations_applied (version, checksum) VALUES (2, 'it''s') ON CONFLICT (version) DO UPDATE SET checksum = EXCLUDED.checksum",
+			"UPDATE schema_migrations SET version = 2",
+		}, statements)
+	})
+
+	t.Run("success: setup creates the version row", func(t *testing.T) {
+		statements := pg.SetupStatements()
+		require.Contains(t, statements, "INSERT INTO schema_migrations (version) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM schema_migrations)")
+	})
+
+	t.Run("error: queries aren't renderable", func(t *testing.T) {
+		_, err := pg.Record(ctx, func(tx *adapter.Versioner) error {
+			_, err := tx.GetCurrentVersion(ctx)
+			return err
+		})
+		require.ErrorIs(t, err, migrate.ErrNotRenderable)
+	})
+
+	t.Run("error: the transaction isn't renderable", func(t *testing.T) {
+		_, err := pg.Record(ctx, func(tx *adapter.Versioner) error {
+			_, err := tx.Tx.Exec(ctx, "DELETE FROM users")
+			return err
+		})
+		require.ErrorIs(t, err, migrate.ErrNotRenderable)
+	})
+}
+
+func TestLoadScriptMigrations(t *testing.T) {
+	t.Run("success: loads every migration of the directory", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT)")},
+			"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+			"migrations/0002_drop_legacy.up.sql":    {Data: []byte("DROP TABLE legacy")},
+		}
+
+		migrations, err := adapter.LoadScriptMigrations(fileSystem, "migrations")
+		require.NoError(t, err)
+		require.Len(t, migrations, 2)
+
+		first := migrations[0].(*adapter.ScriptMigration)
+		require.EqualValues(t, 1, first.Version())
+		require.Equal(t, "create_users", first.Name())
+		require.False(t, first.Irreversible())
+
+		second := migrations[1].(*adapter.ScriptMigration)
+		require.EqualValues(t, 2, second.Version())
+		require.True(t, second.Irreversible())
+	})
+
+	t.Run("error: orphaned down script", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+		}
+
+		_, err := adapter.LoadScriptMigrations(fileSystem, ".")
+		require.ErrorIs(t, err, migrate.ErrOrphanedScript)
+	})
+}
@@ -0,0 +1,89 @@
+package adapter
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type (
+	// detachedTx is the transaction embedded by versioners that don't run in one,
+	// when recording statements or running NonTransactional migrations.
+	// Its methods fail with err, instead of dereferencing a nil pgx.Tx.
+	detachedTx struct {
+		err error
+	}
+
+	// errBatch is the result of a batch that couldn't be sent.
+	errBatch struct {
+		err error
+	}
+)
+
+var (
+	_ pgx.Tx           = detachedTx{}
+	_ pgx.BatchResults = errBatch{}
+)
+
+func (t detachedTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return nil, t.err
+}
+
+func (t detachedTx) Commit(ctx context.Context) error {
+	return t.err
+}
+
+func (t detachedTx) Rollback(ctx context.Context) error {
+	return t.err
+}
+
+func (t detachedTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return 0, t.err
+}
+
+func (t detachedTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return errBatch{err: t.err}
+}
+
+// LargeObjects isn't available outside a transaction. Its methods panic.
+func (t detachedTx) LargeObjects() pgx.LargeObjects {
+	return pgx.LargeObjects{}
+}
+
+func (t detachedTx) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	return nil, t.err
+}
+
+func (t detachedTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, t.err
+}
+
+func (t detachedTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, t.err
+}
+
+func (t detachedTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return errRow{err: t.err}
+}
+
+// Conn returns nil, as there's no connection reserved for the transaction.
+func (t detachedTx) Conn() *pgx.Conn {
+	return nil
+}
+
+func (b errBatch) Exec() (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, b.err
+}
+
+func (b errBatch) Query() (pgx.Rows, error) {
+	return nil, b.err
+}
+
+func (b errBatch) QueryRow() pgx.Row {
+	return errRow{err: b.err}
+}
+
+func (b errBatch) Close() error {
+	return b.err
+}
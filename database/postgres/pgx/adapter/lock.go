@@ -0,0 +1,300 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+// lockRetryInterval is how long to wait between attempts to acquire an advisory lock.
+const lockRetryInterval = 100 * time.Millisecond
+
+type (
+	// session is a database session able to hold a session level advisory lock.
+	// It's implemented by *pgx.Conn, *pgxpool.Conn and pgx.Tx.
+	session interface {
+		QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+		Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	}
+
+	// pool is a database that hands out dedicated connections, such as *pgxpool.Pool.
+	pool interface {
+		Acquire(ctx context.Context) (*pgxpool.Conn, error)
+	}
+
+	// runLock is the advisory lock held by Lock, until Unlock is called.
+	runLock struct {
+		session session
+		release func(ctx context.Context) error
+	}
+)
+
+// WithLockKey sets the key of the advisory lock taken while migrating.
+// It defaults to a hash of the schema migrations table name, so that migrators sharing a table exclude each other.
+func WithLockKey(key int64) Option {
+	return func(p *Config) {
+		p.lockKey = key
+	}
+}
+
+// WithLockTimeout sets how long to wait for the advisory lock, before failing with migrate.ErrLockTimeout.
+// By default, it waits until the context is canceled.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(p *Config) {
+		p.lockTimeout = timeout
+	}
+}
+
+// WithLockTableName sets the table name for the lease used by migrate.NewLeaseLocker.
+// It defaults to the schema migrations table name with the "_lock" suffix.
+func WithLockTableName(name string) Option {
+	return func(p *Config) {
+		p.lockTableName = name
+	}
+}
+
+// leaseTableQuery returns the query that creates the table storing the lease.
+func (c Config) leaseTableQuery() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id INT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`, c.lockTableName)
+}
+
+// AcquireLease takes the lease stored in the lock table, if it's free, expired, or already held by the owner.
+// Together with migrate.NewLeaseLocker, it's an alternative to the advisory lock, that expires if the process crashes.
+// The heartbeat runs concurrently with the migrations, so the database must be a pool, such as *pgxpool.Pool.
+func (p *Postgres) AcquireLease(ctx context.Context, owner string, ttl time.Duration) (acquired bool, err error) {
+	query := fmt.Sprintf(`INSERT INTO %[1]s (id, owner, expires_at) VALUES (1, $1, now() + $2::float8 * interval '1 second')
+		ON CONFLICT (id) DO UPDATE SET owner = EXCLUDED.owner, expires_at = EXCLUDED.expires_at
+		WHERE %[1]s.expires_at < now() OR %[1]s.owner = EXCLUDED.owner`, p.config.lockTableName)
+
+	err = p.leaseTransaction(ctx, func(tx pgx.Tx) error {
+		cmd, err := tx.Exec(ctx, query, owner, ttl.Seconds())
+		if err != nil {
+			return fmt.Errorf("failed to acquire lease: %w", err)
+		}
+		acquired = cmd.RowsAffected() == 1
+		return nil
+	})
+	return acquired, err
+}
+
+// RenewLease extends the lease stored in the lock table, if it's held by the owner.
+func (p *Postgres) RenewLease(ctx context.Context, owner string, ttl time.Duration) (renewed bool, err error) {
+	query := fmt.Sprintf("UPDATE %s SET expires_at = now() + $2::float8 * interval '1 second' WHERE id = 1 AND owner = $1", p.config.lockTableName)
+
+	err = p.leaseTransaction(ctx, func(tx pgx.Tx) error {
+		cmd, err := tx.Exec(ctx, query, owner, ttl.Seconds())
+		if err != nil {
+			return fmt.Errorf("failed to renew lease: %w", err)
+		}
+		renewed = cmd.RowsAffected() == 1
+		return nil
+	})
+	return renewed, err
+}
+
+// ReleaseLease frees the lease stored in the lock table, if it's held by the owner.
+func (p *Postgres) ReleaseLease(ctx context.Context, owner string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND owner = $1", p.config.lockTableName)
+
+	return p.leaseTransaction(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, query, owner); err != nil {
+			return fmt.Errorf("failed to release lease: %w", err)
+		}
+		return nil
+	})
+}
+
+// leaseTransaction runs the handler in a transaction, after creating the lock table.
+// Concurrent table creation is serialized by an advisory lock, keyed by the lock table name,
+// so it never waits for the migration transactions.
+func (p *Postgres) leaseTransaction(ctx context.Context, handler func(tx pgx.Tx) error) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", lockKey(p.config.lockTableName)); err != nil {
+		return fmt.Errorf("failed to lock lease table: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, p.config.leaseTableQuery()); err != nil {
+		return fmt.Errorf("failed to create lease table: %w", err)
+	}
+
+	if err := handler(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// lockKey derives the advisory lock key from the table name.
+func lockKey(tableName string) int64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(tableName))
+	return int64(hash.Sum64())
+}
+
+// Lock acquires a session level advisory lock, held until Unlock is called.
+// It prevents other processes from migrating the same database, for the whole run.
+// While it's held, Transaction doesn't take its own transaction level lock.
+// Callers sharing this instance wait for each other, like other processes do.
+func (p *Postgres) Lock(ctx context.Context) error {
+	err := p.config.waitLock(ctx, func(context.Context) (bool, error) {
+		select {
+		case p.lockSlot <- struct{}{}:
+			return true, nil
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	lock, err := p.openSession(ctx)
+	if err != nil {
+		<-p.lockSlot
+		return fmt.Errorf("failed to open lock session: %w", err)
+	}
+
+	err = p.config.waitLock(ctx, func(ctx context.Context) (acquired bool, err error) {
+		err = lock.session.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", p.config.lockKey).Scan(&acquired)
+		return acquired, err
+	})
+	if err != nil {
+		_ = lock.release(ctx)
+		<-p.lockSlot
+		return err
+	}
+
+	p.lockMutex.Lock()
+	p.lock = lock
+	p.lockMutex.Unlock()
+
+	p.config.logger.DebugContext(ctx, "lock acquired", "key", p.config.lockKey)
+	return nil
+}
+
+// Unlock releases the advisory lock acquired by Lock.
+func (p *Postgres) Unlock(ctx context.Context) error {
+	p.lockMutex.Lock()
+	lock := p.lock
+	p.lock = nil
+	p.lockMutex.Unlock()
+
+	if lock == nil {
+		return nil
+	}
+	defer func() { <-p.lockSlot }()
+
+	_, err := lock.session.Exec(ctx, "SELECT pg_advisory_unlock($1)", p.config.lockKey)
+	if releaseErr := lock.release(ctx); err == nil && releaseErr != nil {
+		err = releaseErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+
+	p.config.logger.DebugContext(ctx, "lock released", "key", p.config.lockKey)
+	return nil
+}
+
+// isLocked tells if this instance holds the lock acquired by Lock.
+func (p *Postgres) isLocked() bool {
+	p.lockMutex.Lock()
+	defer p.lockMutex.Unlock()
+
+	return p.lock != nil
+}
+
+// openSession returns a session dedicated to holding the lock.
+// Pools hand out a dedicated connection, and single connections are used directly.
+// Any other database holds the lock in a transaction.
+func (p *Postgres) openSession(ctx context.Context) (*runLock, error) {
+	switch db := p.db.(type) {
+	case pool:
+		conn, err := db.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &runLock{
+			session: conn,
+			release: func(context.Context) error {
+				conn.Release()
+				return nil
+			},
+		}, nil
+	case session:
+		return &runLock{
+			session: db,
+			release: func(context.Context) error { return nil },
+		}, nil
+	default:
+		tx, err := p.db.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &runLock{
+			session: tx,
+			release: tx.Rollback,
+		}, nil
+	}
+}
+
+// lockTransaction acquires a transaction level advisory lock, released when the transaction ends.
+func (p *Postgres) lockTransaction(ctx context.Context, tx pgx.Tx) error {
+	return p.config.waitLock(ctx, func(ctx context.Context) (acquired bool, err error) {
+		err = tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", p.config.lockKey).Scan(&acquired)
+		return acquired, err
+	})
+}
+
+// waitLock calls try until it acquires the lock, the lock timeout expires, or the context is canceled.
+func (c Config) waitLock(ctx context.Context, try func(ctx context.Context) (bool, error)) error {
+	lockCtx := ctx
+	if c.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, c.lockTimeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(lockRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := try(lockCtx)
+
+		switch {
+		case acquired:
+			return nil
+		case ctx.Err() != nil:
+			return fmt.Errorf("failed to acquire lock: %w", ctx.Err())
+		case lockCtx.Err() != nil:
+			return fmt.Errorf("%w: key %d, after %s", migrate.ErrLockTimeout, c.lockKey, c.lockTimeout)
+		case err != nil:
+			return fmt.Errorf("failed to acquire lock: %w", err)
+		}
+
+		select {
+		case <-lockCtx.Done():
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,18 +2,53 @@ package adapter
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/sonalys/codemigrate/migrate/sqlscript"
 )
 
 type ScriptMigration struct {
 	version    int64
 	upScript   string
 	downScript string
+	checksum   string
+	// upStatements and downStatements are the scripts split into statements.
+	upStatements   []sqlscript.Statement
+	downStatements []sqlscript.Statement
+	// upFile and downFile are the paths of the scripts defining each direction, used to locate errors.
+	upFile   string
+	downFile string
+	// name is parsed from the script file names by LoadScriptMigrations.
+	name string
+	// noTransaction is set by the NoTransaction directive.
+	noTransaction bool
+}
+
+var (
+	_ migrate.Checksummer      = (*ScriptMigration)(nil)
+	_ migrate.NonTransactional = (*ScriptMigration)(nil)
+	_ migrate.Irreversible     = (*ScriptMigration)(nil)
+
+	// Errors of failed statements keep the driver error, so migrate.MigrationError reports its SQLSTATE code.
+	_ migrate.SQLStater = (*pgconn.PgError)(nil)
+)
+
+// GoStub generates Go migrations for this adapter, with migrate.NewScaffold.
+var GoStub = migrate.GoStub{
+	Imports:   []string{"github.com/sonalys/codemigrate/database/postgres/pgx/adapter"},
+	Versioner: "*adapter.Versioner",
 }
 
 // NewScriptMigrationFromString creates a new Migration from a given file.
+// Without a downScriptPath, the migration is irreversible, unless the up script has a Down section.
 func NewScriptMigrationFromFile(
 	version int64,
 	fileSystem fs.FS,
@@ -29,28 +64,115 @@ func NewScriptMigrationFromFile(
 		return nil, err
 	}
 
-	downScript, err := readFileContent(fileSystem, downScriptPath)
+	// Without a down script, the migration is irreversible.
+	var downScript string
+	if downScriptPath != "" {
+		downScript, err = readFileContent(fileSystem, downScriptPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newScriptMigration(version, upScriptPath, upScript, downScriptPath, downScript)
+}
+
+// LoadScriptMigrations creates a Migration for each pair of scripts in the directory of the file system, such as an embed.FS.
+// Scripts are named NNNN_description.up.sql and NNNN_description.down.sql, as described by migrate.FindScripts.
+// Migrations without a down script are irreversible.
+func LoadScriptMigrations(fileSystem fs.FS, dir string) ([]migrate.Migration[*Versioner], error) {
+	scripts, err := migrate.FindScripts(fileSystem, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migrate.Migration[*Versioner], 0, len(scripts))
+
+	for _, script := range scripts {
+		migration, err := NewScriptMigrationFromFile(script.Version, fileSystem, script.UpPath, script.DownPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading migration %d: %w", script.Version, err)
+		}
+
+		migration.name = script.Name
+		migrations = append(migrations, migration)
+	}
+
+	return migrations, nil
+}
+
+// newScriptMigration parses the directives of the scripts.
+// Either script may carry both directions in Up and Down sections, as long as each direction is defined once.
+func newScriptMigration(version int64, upPath, upContent, downPath, downContent string) (*ScriptMigration, error) {
+	upScript, err := sqlscript.Parse(upContent, migrate.DirectionUp)
+	if err != nil {
+		return nil, fmt.Errorf("parsing up script: %w", err)
+	}
+
+	downScript, err := sqlscript.Parse(downContent, migrate.DirectionDown)
+	if err != nil {
+		return nil, fmt.Errorf("parsing down script: %w", err)
+	}
+
+	up, upFile, err := mergeSections(migrate.DirectionUp, upScript.Up, upPath, downScript.Up, downPath)
+	if err != nil {
+		return nil, err
+	}
+
+	down, downFile, err := mergeSections(migrate.DirectionDown, upScript.Down, upPath, downScript.Down, downPath)
 	if err != nil {
 		return nil, err
 	}
 
 	migration := &ScriptMigration{
-		version:    version,
-		upScript:   upScript,
-		downScript: downScript,
+		version:        version,
+		upScript:       up.Text,
+		downScript:     down.Text,
+		checksum:       checksum(upContent, downContent),
+		upStatements:   up.Statements,
+		downStatements: down.Statements,
+		upFile:         upFile,
+		downFile:       downFile,
+		noTransaction:  upScript.NoTransaction || downScript.NoTransaction,
 	}
 
 	return migration, nil
 }
 
-func readFileContent(fileSystem fs.FS, filePath string) (string, error) {
+// mergeSections returns the section of the direction defined by either script, and the path of that script.
+func mergeSections(
+	direction migrate.Direction,
+	fromUp sqlscript.Section, upPath string,
+	fromDown sqlscript.Section, downPath string,
+) (sqlscript.Section, string, error) {
+	switch {
+	case strings.TrimSpace(fromDown.Text) == "":
+		return fromUp, upPath, nil
+	case strings.TrimSpace(fromUp.Text) == "":
+		return fromDown, downPath, nil
+	default:
+		return sqlscript.Section{}, "", fmt.Errorf("%w: %s section defined by both scripts", sqlscript.ErrDirective, direction)
+	}
+}
+
+// checksum returns the hex encoded SHA-256 digest of the scripts, including both sections and every directive.
+// Each script is followed by a NUL byte, so that moving content from one script to the other changes the digest.
+func checksum(scripts ...string) string {
+	hash := sha256.New()
+	for _, script := range scripts {
+		hash.Write([]byte(script))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func readFileContent(fileSystem fs.FS, filePath string) (_ string, err error) {
 	file, err := fileSystem.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read file: %w", err)
 	}
 	defer func() {
-		if err := file.Close(); err != nil {
-			fmt.Printf("failed to close file: %v\n", err)
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close file: %w", closeErr)
 		}
 	}()
 
@@ -63,6 +185,7 @@ func readFileContent(fileSystem fs.FS, filePath string) (string, error) {
 }
 
 // NewScriptMigrationFromReader creates a new Migration from a reader.
+// With a nil downReader, the migration is irreversible.
 func NewScriptMigrationFromReader(
 	version int64,
 	upReader io.Reader,
@@ -88,13 +211,7 @@ func NewScriptMigrationFromReader(
 		return nil, err
 	}
 
-	migration := &ScriptMigration{
-		version:    version,
-		upScript:   string(upContent),
-		downScript: string(downContent),
-	}
-
-	return migration, nil
+	return newScriptMigration(version, "", string(upContent), "", string(downContent))
 }
 
 func safeReadAll(reader io.Reader) ([]byte, error) {
@@ -119,22 +236,84 @@ func closeReader(reader io.Reader) error {
 	return nil
 }
 
-func (m *ScriptMigration) Up(ctx context.Context, tx *Versioner) error {
-	_, err := tx.Exec(ctx, m.upScript)
-	if err != nil {
+func (m *ScriptMigration) Up(ctx context.Context, tx *Versioner) (err error) {
+	tx.config.logger.DebugContext(ctx, "executing up script", "version", m.version)
+
+	ctx, span := tx.config.startSpan(ctx, "ScriptMigration.Up", "", m.upScript)
+	defer func() { endSpan(span, err) }()
+
+	if err := execStatements(ctx, tx, m.upFile, m.upStatements); err != nil {
 		return fmt.Errorf("failed to apply migration %d: %w", m.version, err)
 	}
 	return nil
 }
 
-func (m *ScriptMigration) Down(ctx context.Context, tx *Versioner) error {
-	_, err := tx.Exec(ctx, m.downScript)
-	if err != nil {
+func (m *ScriptMigration) Down(ctx context.Context, tx *Versioner) (err error) {
+	tx.config.logger.DebugContext(ctx, "executing down script", "version", m.version)
+
+	ctx, span := tx.config.startSpan(ctx, "ScriptMigration.Down", "", m.downScript)
+	defer func() { endSpan(span, err) }()
+
+	if err := execStatements(ctx, tx, m.downFile, m.downStatements); err != nil {
 		return fmt.Errorf("failed to revert migration %d: %w", m.version, err)
 	}
 	return nil
 }
 
+// execStatements executes the statements in order, stopping at the first error.
+// The error is located in the script, using the position reported by PostgreSQL.
+func execStatements(ctx context.Context, tx *Versioner, file string, statements []sqlscript.Statement) error {
+	for i, statement := range statements {
+		if _, err := tx.Exec(ctx, statement.SQL); err != nil {
+			return statementError(file, i, statement, err)
+		}
+	}
+	return nil
+}
+
+// statementError locates the error of the statement in its script.
+func statementError(file string, index int, statement sqlscript.Statement, err error) error {
+	line, column := statement.Line, statement.Column
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Position > 0 {
+		line, column = statement.Position(int(pgErr.Position))
+	}
+
+	return &sqlscript.StatementError{
+		File:      file,
+		Statement: index + 1,
+		Line:      line,
+		Column:    column,
+		Err:       err,
+	}
+}
+
 func (m *ScriptMigration) Version() int64 {
 	return m.version
 }
+
+// Name returns the description of the migration, parsed from its file names by LoadScriptMigrations.
+// It's empty for migrations created otherwise.
+func (m *ScriptMigration) Name() string {
+	return m.name
+}
+
+// NoTransaction tells if the scripts must run outside a transaction.
+// It's set by a "-- +codemigrate NoTransaction" line in either script.
+// Without a transaction, each statement is committed as it runs, so a failure leaves the earlier statements applied.
+func (m *ScriptMigration) NoTransaction() bool {
+	return m.noTransaction
+}
+
+// Irreversible tells if the migration has no down script, either because it was omitted or is empty.
+// The migrator refuses to revert it, instead of moving the version back without changing the schema.
+func (m *ScriptMigration) Irreversible() bool {
+	return len(m.downStatements) == 0
+}
+
+// Checksum returns the SHA-256 digest of the up and down scripts, directives included.
+// It's stored when the migration is applied, to detect if the script is edited afterwards.
+func (m *ScriptMigration) Checksum() string {
+	return m.checksum
+}
@@ -0,0 +1,134 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+type (
+	// recorder is an executor that records statements instead of running them.
+	recorder struct {
+		statements []string
+	}
+
+	// errRow is a row that fails to scan.
+	errRow struct {
+		err error
+	}
+)
+
+var (
+	_ executor = (*recorder)(nil)
+
+	_ migrate.RecordingDatabase[*Versioner] = (*Postgres)(nil)
+)
+
+// placeholderPattern matches the positional parameters of a statement, such as $1.
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// SetupStatements returns the statements that create the tables used to keep track of the migrations.
+// The version table gets a row, so that rendered version changes always update it.
+func (p *Postgres) SetupStatements() []string {
+	return append(p.config.schemaQueries(),
+		p.config.upgradeQuery(),
+		fmt.Sprintf("INSERT INTO %[1]s (version) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM %[1]s)", p.config.tableName),
+	)
+}
+
+// Record calls the handler with a versioner that records every statement instead of running it.
+// Queries fail with migrate.ErrNotRenderable, as their results can't be known in advance,
+// and so does the embedded Tx, as its statements would bypass the recorder.
+func (p *Postgres) Record(ctx context.Context, handler func(tx *Versioner) error) ([]string, error) {
+	recorder := &recorder{}
+
+	versioner := &Versioner{
+		Tx:       detachedTx{err: fmt.Errorf("%w: recording without a transaction", migrate.ErrNotRenderable)},
+		config:   p.config,
+		executor: recorder,
+	}
+
+	if err := handler(versioner); err != nil {
+		return nil, fmt.Errorf("handler error: %w", err)
+	}
+	return recorder.statements, nil
+}
+
+func (r *recorder) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	statement, err := inlineArgs(sql, args)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+
+	r.statements = append(r.statements, statement)
+
+	// Statements are assumed to succeed, changing a single row.
+	return pgconn.NewCommandTag("RECORDED 1"), nil
+}
+
+func (r *recorder) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, fmt.Errorf("%w: query %q", migrate.ErrNotRenderable, sql)
+}
+
+func (r *recorder) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return errRow{err: fmt.Errorf("%w: query %q", migrate.ErrNotRenderable, sql)}
+}
+
+func (r errRow) Scan(dest ...any) error {
+	return r.err
+}
+
+// inlineArgs replaces the positional parameters of the statement by the literal value of its arguments.
+func inlineArgs(sql string, args []any) (string, error) {
+	if len(args) == 0 {
+		return sql, nil
+	}
+
+	literals := make([]string, len(args))
+	for i, arg := range args {
+		value, err := literal(arg)
+		if err != nil {
+			return "", fmt.Errorf("argument $%d: %w", i+1, err)
+		}
+		literals[i] = value
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(sql, func(placeholder string) string {
+		index, _ := strconv.Atoi(placeholder[1:])
+		if index < 1 || index > len(literals) {
+			return placeholder
+		}
+		return literals[index-1]
+	}), nil
+}
+
+// literal formats the value as a PostgreSQL literal.
+func literal(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'", nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int32:
+		return strconv.FormatInt(int64(value), 10), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case time.Time:
+		return "'" + value.Format(time.RFC3339Nano) + "'::timestamptz", nil
+	default:
+		return "", fmt.Errorf("%w: unsupported argument type %T", migrate.ErrNotRenderable, value)
+	}
+}
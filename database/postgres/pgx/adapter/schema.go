@@ -0,0 +1,82 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// missingSchema tells which parts of the migration schema don't exist yet.
+// Reads don't create them, and find an empty state instead.
+type missingSchema struct {
+	versionTable bool
+	historyTable bool
+	appliedTable bool
+	// dirtyColumn is set for version tables created by older releases, that weren't upgraded yet.
+	dirtyColumn bool
+}
+
+// schemaStateQuery tells which migration tables are missing, and if the version table is missing the dirty version column.
+const schemaStateQuery = `SELECT
+	to_regclass($1) IS NULL,
+	to_regclass($2) IS NULL,
+	to_regclass($3) IS NULL,
+	NOT EXISTS (
+		SELECT 1 FROM information_schema.columns c
+		JOIN pg_class r ON r.oid = to_regclass($1)
+		JOIN pg_namespace n ON n.oid = r.relnamespace
+		WHERE c.table_schema = n.nspname AND c.table_name = r.relname AND c.column_name = 'dirty_version'
+	)`
+
+// upgradeQuery returns the query that adds the dirty version to version tables created by older releases.
+func (c Config) upgradeQuery() string {
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS dirty_version BIGINT", c.tableName)
+}
+
+// prepareSchema creates the missing migration tables, and upgrades the version table if it was created by an older release.
+// Reads only inspect the schema: they run without the advisory lock, so creating or altering tables
+// would race with other processes, and fail for read-only users.
+// It returns what's still missing, so that the versioner reads around it.
+func (p *Postgres) prepareSchema(ctx context.Context, tx pgx.Tx, write bool) (missingSchema, error) {
+	if p.schemaReady.Load() {
+		return missingSchema{}, nil
+	}
+
+	missing, err := p.inspectSchema(ctx, tx)
+	if err != nil || !write {
+		return missing, err
+	}
+
+	if missing.versionTable || missing.historyTable || missing.appliedTable {
+		for _, query := range p.config.schemaQueries() {
+			if _, err := tx.Exec(ctx, query); err != nil {
+				return missing, fmt.Errorf("failed to create migration tables: %w", err)
+			}
+		}
+
+		if missing, err = p.inspectSchema(ctx, tx); err != nil {
+			return missing, err
+		}
+	}
+
+	if missing.dirtyColumn {
+		if _, err := tx.Exec(ctx, p.config.upgradeQuery()); err != nil {
+			return missing, fmt.Errorf("failed to upgrade migration tables: %w", err)
+		}
+		p.config.logger.InfoContext(ctx, "upgraded version table", "table", p.config.tableName)
+		missing.dirtyColumn = false
+	}
+
+	return missing, nil
+}
+
+// inspectSchema tells which parts of the migration schema are missing.
+func (p *Postgres) inspectSchema(ctx context.Context, tx pgx.Tx) (missing missingSchema, err error) {
+	err = tx.QueryRow(ctx, schemaStateQuery, p.config.tableName, p.config.historyTableName, p.config.appliedTableName).
+		Scan(&missing.versionTable, &missing.historyTable, &missing.appliedTable, &missing.dirtyColumn)
+	if err != nil {
+		return missing, fmt.Errorf("failed to inspect migration tables: %w", err)
+	}
+	return missing, nil
+}
@@ -0,0 +1,40 @@
+package adapter
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sonalys/codemigrate/database/postgres/pgx/adapter"
+
+// startSpan starts a database client span for the given operation.
+// The table is omitted when empty, such as for script migrations.
+func (c Config) startSpan(ctx context.Context, operation, table, query string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(operation),
+		semconv.DBQueryText(query),
+	}
+
+	if table != "" {
+		attributes = append(attributes, semconv.DBCollectionName(table))
+	}
+
+	return c.tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
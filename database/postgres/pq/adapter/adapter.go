@@ -5,6 +5,15 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sonalys/codemigrate/migrate"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type (
@@ -14,6 +23,8 @@ type (
 
 		Query(query string, args ...interface{}) (*sql.Rows, error)
 		Exec(query string, args ...interface{}) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 	}
 
 	Database[T Transaction] interface {
@@ -21,22 +32,66 @@ type (
 	}
 
 	Config struct {
-		tableName string
+		tableName        string
+		historyTableName string
+		appliedTableName string
+		appName          string
+		hostName         string
+		logger           *slog.Logger
+		tracer           trace.Tracer
+		lockKey          int64
+		lockTimeout      time.Duration
+		lockTableName    string
 	}
 
 	Postgres[T Transaction] struct {
 		db     Database[T]
 		config Config
+
+		// lockSlot is filled while a caller holds the lock, so that other callers of this instance wait.
+		lockSlot  chan struct{}
+		lockMutex sync.Mutex
+		lock      *runLock
+
+		// schemaReady is set once the migration tables are created and upgraded.
+		schemaReady atomic.Bool
 	}
 
+	// Versioner runs the migration statements.
+	// Outside a transaction, such as for NonTransactional migrations, statements are committed as they run,
+	// and Tx is the zero value, so migrations must run statements through Exec and Query instead.
 	Versioner[T Transaction] struct {
-		Tx     T
-		config Config
+		Tx       T
+		config   Config
+		executor executor
+		// ctx is the context of the transaction, that cancels the statements run by Exec and Query.
+		ctx context.Context
+		// missing is set when a read finds parts of the schema that weren't created or upgraded yet.
+		missing missingSchema
+	}
+
+	// executor runs statements, inside or outside a transaction.
+	// It's implemented by Transaction and *sql.DB.
+	executor interface {
+		QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 	}
 
 	Option func(*Config)
 )
 
+var (
+	_ migrate.AppliedVersioner  = (*Versioner[*sql.Tx])(nil)
+	_ migrate.ChecksumVersioner = (*Versioner[*sql.Tx])(nil)
+	_ migrate.DirtyVersioner    = (*Versioner[*sql.Tx])(nil)
+	_ migrate.HistoryVersioner  = (*Versioner[*sql.Tx])(nil)
+	_ migrate.Lease             = (*Postgres[*sql.Tx])(nil)
+	_ migrate.Locker            = (*Postgres[*sql.Tx])(nil)
+
+	_ migrate.NonTransactionalDatabase[*Versioner[*sql.Tx]] = (*Postgres[*sql.Tx])(nil)
+	_ migrate.ReadOnlyDatabase[*Versioner[*sql.Tx]]         = (*Postgres[*sql.Tx])(nil)
+)
+
 // WithTableName sets the table name for the schema migrations table.
 func WithTableName(name string) Option {
 	return func(p *Config) {
@@ -44,11 +99,60 @@ func WithTableName(name string) Option {
 	}
 }
 
+// WithHistoryTableName sets the table name for the migration history table.
+// It defaults to the schema migrations table name with the "_history" suffix.
+func WithHistoryTableName(name string) Option {
+	return func(p *Config) {
+		p.historyTableName = name
+	}
+}
+
+// WithAppliedTableName sets the table name for the applied versions table.
+// It defaults to the schema migrations table name with the "_applied" suffix.
+func WithAppliedTableName(name string) Option {
+	return func(p *Config) {
+		p.appliedTableName = name
+	}
+}
+
+// WithLogger sets the logger used to report transactions and version changes.
+// By default, nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Config) {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+		p.logger = logger
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used to trace the versioner queries and script migrations.
+// By default, nothing is traced.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(p *Config) {
+		if provider == nil {
+			provider = noop.NewTracerProvider()
+		}
+		p.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// WithAppName sets the application name recorded in the migration history.
+func WithAppName(name string) Option {
+	return func(p *Config) {
+		p.appName = name
+	}
+}
+
 func From[T Transaction](db Database[T], opts ...Option) *Postgres[T] {
 	postgres := &Postgres[T]{
-		db: db,
+		db:       db,
+		lockSlot: make(chan struct{}, 1),
 		config: Config{
 			tableName: "schema_migrations",
+			hostName:  hostName(),
+			logger:    slog.New(slog.DiscardHandler),
+			tracer:    noop.NewTracerProvider().Tracer(tracerName),
 		},
 	}
 
@@ -56,10 +160,60 @@ func From[T Transaction](db Database[T], opts ...Option) *Postgres[T] {
 		opt(&postgres.config)
 	}
 
+	if postgres.config.historyTableName == "" {
+		postgres.config.historyTableName = postgres.config.tableName + "_history"
+	}
+
+	if postgres.config.appliedTableName == "" {
+		postgres.config.appliedTableName = postgres.config.tableName + "_applied"
+	}
+
+	if postgres.config.lockTableName == "" {
+		postgres.config.lockTableName = postgres.config.tableName + "_lock"
+	}
+
+	if postgres.config.lockKey == 0 {
+		postgres.config.lockKey = lockKey(postgres.config.tableName)
+	}
+
 	return postgres
 }
 
+// schemaQueries returns the queries that create the tables used to keep track of the migrations.
+func (c Config) schemaQueries() []string {
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, dirty_version BIGINT)", c.tableName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			version BIGINT NOT NULL,
+			direction TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			host TEXT NOT NULL,
+			app TEXT NOT NULL
+		)`, c.historyTableName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			version BIGINT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			checksum TEXT
+		)`, c.appliedTableName),
+	}
+}
+
+// Transaction calls the handler in a transaction that changes the migration state.
+// Unless Lock is held, it waits for the advisory lock, released when the transaction ends.
 func (p *Postgres[T]) Transaction(ctx context.Context, handler func(tx *Versioner[T]) error) error {
+	return p.transaction(ctx, true, handler)
+}
+
+// ReadTransaction calls the handler in a transaction that only reads the migration state.
+// It doesn't wait for the advisory lock, so it doesn't block while another process migrates.
+func (p *Postgres[T]) ReadTransaction(ctx context.Context, handler func(tx *Versioner[T]) error) error {
+	return p.transaction(ctx, false, handler)
+}
+
+// transaction calls the handler in a transaction. When write is set, it takes the advisory lock, unless Lock holds it.
+func (p *Postgres[T]) transaction(ctx context.Context, write bool, handler func(tx *Versioner[T]) error) error {
 	tx, err := p.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -68,28 +222,100 @@ func (p *Postgres[T]) Transaction(ctx context.Context, handler func(tx *Versione
 		_ = tx.Rollback()
 	}()
 
-	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY)", p.config.tableName)
+	p.config.logger.DebugContext(ctx, "transaction started")
+
+	if write && !p.isLocked() {
+		if err := p.lockTransaction(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	missing, err := p.prepareSchema(ctx, tx, write)
+	if err != nil {
+		return err
+	}
+
+	versioner := &Versioner[T]{
+		Tx:       tx,
+		config:   p.config,
+		executor: tx,
+		ctx:      ctx,
+		missing:  missing,
+	}
+
+	if err := handler(versioner); err != nil {
+		p.config.logger.DebugContext(ctx, "transaction rolled back", "error", err)
+		return fmt.Errorf("handler error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if missing == (missingSchema{}) {
+		p.schemaReady.Store(true)
+	}
+
+	p.config.logger.DebugContext(ctx, "transaction committed")
+	return nil
+}
 
-	if _, err = tx.Exec(query); err != nil {
-		return fmt.Errorf("failed to create %s table: %w", p.config.tableName, err)
+// WithoutTransaction calls the handler with a versioner that runs statements directly on the database.
+// Each statement is committed as it runs. It's used by NonTransactional migrations.
+// The database must run statements by itself, such as *sql.DB.
+func (p *Postgres[T]) WithoutTransaction(ctx context.Context, handler func(tx *Versioner[T]) error) error {
+	db, ok := p.db.(executor)
+	if !ok {
+		return fmt.Errorf("%w: %T can't run statements", migrate.ErrNoTransactionNotSupported, p.db)
 	}
 
+	p.config.logger.DebugContext(ctx, "running without transaction")
+
 	versioner := &Versioner[T]{
-		Tx:     tx,
-		config: p.config,
+		config:   p.config,
+		executor: db,
+		ctx:      ctx,
 	}
 
 	if err := handler(versioner); err != nil {
 		return fmt.Errorf("handler error: %w", err)
 	}
+	return nil
+}
+
+// Exec runs the statement in the transaction, or directly on the database outside a transaction.
+// It's canceled along with the context of the transaction.
+func (p *Versioner[T]) Exec(query string, args ...any) (sql.Result, error) {
+	return p.ExecContext(p.ctx, query, args...)
+}
+
+// Query runs the query in the transaction, or directly on the database outside a transaction.
+// It's canceled along with the context of the transaction.
+func (p *Versioner[T]) Query(query string, args ...any) (*sql.Rows, error) {
+	return p.QueryContext(p.ctx, query, args...)
+}
+
+// ExecContext runs the statement like Exec, canceled along with the given context.
+func (p *Versioner[T]) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return p.executor.ExecContext(ctx, query, args...)
+}
 
-	return tx.Commit()
+// QueryContext runs the query like Query, canceled along with the given context.
+func (p *Versioner[T]) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return p.executor.QueryContext(ctx, query, args...)
 }
 
-func (p *Versioner[T]) GetCurrentVersion(ctx context.Context) (int64, error) {
+func (p *Versioner[T]) GetCurrentVersion(ctx context.Context) (_ int64, err error) {
 	query := fmt.Sprintf("SELECT version FROM %s", p.config.tableName)
 
-	row, err := p.Tx.Query(query)
+	ctx, span := p.config.startSpan(ctx, "GetCurrentVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.versionTable {
+		return 0, nil
+	}
+
+	row, err := p.QueryContext(ctx, query)
 	if err != nil {
 		return 0, fmt.Errorf("failed to query %s: %w", p.config.tableName, err)
 	}
@@ -106,13 +332,18 @@ func (p *Versioner[T]) GetCurrentVersion(ctx context.Context) (int64, error) {
 	if err := row.Scan(&version); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return 0, fmt.Errorf("failed to scan version: %w", err)
 	}
+
+	p.config.logger.DebugContext(ctx, "read current version", "version", version)
 	return version, nil
 }
 
-func (p *Versioner[T]) SetVersion(ctx context.Context, version int64) error {
+func (p *Versioner[T]) SetVersion(ctx context.Context, version int64) (err error) {
 	query := fmt.Sprintf("UPDATE %s SET version = $1", p.config.tableName)
 
-	cmd, err := p.Tx.Exec(query, version)
+	ctx, span := p.config.startSpan(ctx, "SetVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	cmd, err := p.ExecContext(ctx, query, version)
 	if err != nil {
 		return fmt.Errorf("failed to update version: %w", err)
 	}
@@ -124,10 +355,265 @@ func (p *Versioner[T]) SetVersion(ctx context.Context, version int64) error {
 
 	if rowsAffected == 0 {
 		query = fmt.Sprintf("INSERT INTO %s VALUES ($1)", p.config.tableName)
-		_, err := p.Tx.Exec(query, version)
+		_, err := p.ExecContext(ctx, query, version)
 		if err != nil {
 			return fmt.Errorf("failed to insert default version: %w", err)
 		}
 	}
+
+	p.config.logger.DebugContext(ctx, "set version", "version", version)
+	return nil
+}
+
+func (p *Versioner[T]) RecordHistory(ctx context.Context, entry migrate.HistoryEntry) (err error) {
+	query := fmt.Sprintf(
+		"INSERT INTO %s (version, direction, applied_at, duration_ms, host, app) VALUES ($1, $2, $3, $4, $5, $6)",
+		p.config.historyTableName,
+	)
+
+	ctx, span := p.config.startSpan(ctx, "RecordHistory", p.config.historyTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.ExecContext(ctx, query,
+		entry.Version,
+		string(entry.Direction),
+		entry.AppliedAt,
+		entry.Duration.Milliseconds(),
+		p.config.hostName,
+		p.config.appName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert history entry: %w", err)
+	}
+	return nil
+}
+
+func (p *Versioner[T]) GetHistory(ctx context.Context) (_ []migrate.HistoryEntry, err error) {
+	query := fmt.Sprintf(
+		"SELECT version, direction, applied_at, duration_ms, host, app FROM %s ORDER BY id",
+		p.config.historyTableName,
+	)
+
+	ctx, span := p.config.startSpan(ctx, "GetHistory", p.config.historyTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.historyTable {
+		return nil, nil
+	}
+
+	rows, err := p.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", p.config.historyTableName, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var history []migrate.HistoryEntry
+
+	for rows.Next() {
+		var (
+			entry      migrate.HistoryEntry
+			direction  string
+			durationMs int64
+		)
+
+		if err := rows.Scan(&entry.Version, &direction, &entry.AppliedAt, &durationMs, &entry.Host, &entry.App); err != nil {
+			return nil, fmt.Errorf("failed to scan history entry: %w", err)
+		}
+
+		entry.Direction = migrate.Direction(direction)
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return history, nil
+}
+
+func (p *Versioner[T]) GetAppliedVersions(ctx context.Context) (_ []int64, err error) {
+	query := fmt.Sprintf("SELECT version FROM %s", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "GetAppliedVersions", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.appliedTable {
+		return nil, nil
+	}
+
+	rows, err := p.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", p.config.appliedTableName, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var versions []int64
+
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan applied version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (p *Versioner[T]) MarkApplied(ctx context.Context, version int64) (err error) {
+	query := fmt.Sprintf("INSERT INTO %s (version) VALUES ($1) ON CONFLICT (version) DO NOTHING", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "MarkApplied", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.ExecContext(ctx, query, version); err != nil {
+		return fmt.Errorf("failed to insert applied version: %w", err)
+	}
+	return nil
+}
+
+func (p *Versioner[T]) MarkReverted(ctx context.Context, version int64) (err error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE version = $1", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "MarkReverted", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.ExecContext(ctx, query, version); err != nil {
+		return fmt.Errorf("failed to delete applied version: %w", err)
+	}
+	return nil
+}
+
+func (p *Versioner[T]) GetChecksums(ctx context.Context) (_ map[int64]string, err error) {
+	query := fmt.Sprintf("SELECT version, checksum FROM %s WHERE checksum IS NOT NULL", p.config.appliedTableName)
+
+	ctx, span := p.config.startSpan(ctx, "GetChecksums", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.appliedTable {
+		return map[int64]string{}, nil
+	}
+
+	rows, err := p.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", p.config.appliedTableName, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	checksums := make(map[int64]string)
+
+	for rows.Next() {
+		var (
+			version  int64
+			checksum string
+		)
+
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, fmt.Errorf("failed to scan checksum: %w", err)
+		}
+		checksums[version] = checksum
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksums: %w", err)
+	}
+	return checksums, nil
+}
+
+func (p *Versioner[T]) SetChecksum(ctx context.Context, version int64, checksum string) (err error) {
+	query := fmt.Sprintf(
+		"INSERT INTO %s (version, checksum) VALUES ($1, $2) ON CONFLICT (version) DO UPDATE SET checksum = EXCLUDED.checksum",
+		p.config.appliedTableName,
+	)
+
+	ctx, span := p.config.startSpan(ctx, "SetChecksum", p.config.appliedTableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.ExecContext(ctx, query, version, checksum); err != nil {
+		return fmt.Errorf("failed to store checksum: %w", err)
+	}
+	return nil
+}
+
+func (p *Versioner[T]) GetDirtyVersion(ctx context.Context) (_ int64, _ bool, err error) {
+	query := fmt.Sprintf("SELECT dirty_version FROM %s", p.config.tableName)
+
+	ctx, span := p.config.startSpan(ctx, "GetDirtyVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if p.missing.dirtyColumn {
+		return 0, false, nil
+	}
+
+	rows, err := p.QueryContext(ctx, query)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to query %s: %w", p.config.tableName, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, false, rows.Err()
+	}
+
+	var version sql.NullInt64
+
+	if err := rows.Scan(&version); err != nil {
+		return 0, false, fmt.Errorf("failed to scan dirty version: %w", err)
+	}
+	return version.Int64, version.Valid, nil
+}
+
+func (p *Versioner[T]) SetDirtyVersion(ctx context.Context, version int64) (err error) {
+	query := fmt.Sprintf("UPDATE %s SET dirty_version = $1", p.config.tableName)
+
+	ctx, span := p.config.startSpan(ctx, "SetDirtyVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := p.ExecContext(ctx, query, version)
+	if err != nil {
+		return fmt.Errorf("failed to update dirty version: %w", err)
+	}
+
+	if updated, err := affectedOne(result); err != nil {
+		return err
+	} else if !updated {
+		query = fmt.Sprintf("INSERT INTO %s (version, dirty_version) VALUES (0, $1)", p.config.tableName)
+		if _, err := p.ExecContext(ctx, query, version); err != nil {
+			return fmt.Errorf("failed to insert dirty version: %w", err)
+		}
+	}
+
+	p.config.logger.DebugContext(ctx, "set dirty version", "version", version)
+	return nil
+}
+
+func (p *Versioner[T]) ClearDirtyVersion(ctx context.Context) (err error) {
+	query := fmt.Sprintf("UPDATE %s SET dirty_version = NULL", p.config.tableName)
+
+	ctx, span := p.config.startSpan(ctx, "ClearDirtyVersion", p.config.tableName, query)
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to clear dirty version: %w", err)
+	}
+
+	p.config.logger.DebugContext(ctx, "cleared dirty version")
 	return nil
 }
+
+func hostName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
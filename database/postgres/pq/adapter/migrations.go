@@ -2,18 +2,55 @@ package adapter
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/sonalys/codemigrate/migrate/sqlscript"
 )
 
 type ScriptMigration[T Transaction] struct {
 	version    int64
 	upScript   string
 	downScript string
+	checksum   string
+	// upStatements and downStatements are the scripts split into statements.
+	upStatements   []sqlscript.Statement
+	downStatements []sqlscript.Statement
+	// upFile and downFile are the paths of the scripts defining each direction, used to locate errors.
+	upFile   string
+	downFile string
+	// name is parsed from the script file names by LoadScriptMigrations.
+	name string
+	// noTransaction is set by the NoTransaction directive.
+	noTransaction bool
+}
+
+var (
+	_ migrate.Checksummer      = (*ScriptMigration[*sql.Tx])(nil)
+	_ migrate.NonTransactional = (*ScriptMigration[*sql.Tx])(nil)
+	_ migrate.Irreversible     = (*ScriptMigration[*sql.Tx])(nil)
+
+	// Errors of failed statements keep the driver error, so migrate.MigrationError reports its SQLSTATE code.
+	_ migrate.SQLStater = (*pq.Error)(nil)
+)
+
+// GoStub generates Go migrations for this adapter, with migrate.NewScaffold.
+var GoStub = migrate.GoStub{
+	Imports:   []string{"database/sql", "github.com/sonalys/codemigrate/database/postgres/pq/adapter"},
+	Versioner: "*adapter.Versioner[*sql.Tx]",
 }
 
 // NewScriptMigrationFromString creates a new Migration from a given file.
+// Without a downScriptPath, the migration is irreversible, unless the up script has a Down section.
 func NewScriptMigrationFromFile[T Transaction](
 	version int64,
 	fileSystem fs.FS,
@@ -29,28 +66,115 @@ func NewScriptMigrationFromFile[T Transaction](
 		return nil, err
 	}
 
-	downScript, err := readFileContent(fileSystem, downScriptPath)
+	// Without a down script, the migration is irreversible.
+	var downScript string
+	if downScriptPath != "" {
+		downScript, err = readFileContent(fileSystem, downScriptPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newScriptMigration[T](version, upScriptPath, upScript, downScriptPath, downScript)
+}
+
+// LoadScriptMigrations creates a Migration for each pair of scripts in the directory of the file system, such as an embed.FS.
+// Scripts are named NNNN_description.up.sql and NNNN_description.down.sql, as described by migrate.FindScripts.
+// Migrations without a down script are irreversible.
+func LoadScriptMigrations[T Transaction](fileSystem fs.FS, dir string) ([]migrate.Migration[*Versioner[T]], error) {
+	scripts, err := migrate.FindScripts(fileSystem, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migrate.Migration[*Versioner[T]], 0, len(scripts))
+
+	for _, script := range scripts {
+		migration, err := NewScriptMigrationFromFile[T](script.Version, fileSystem, script.UpPath, script.DownPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading migration %d: %w", script.Version, err)
+		}
+
+		migration.name = script.Name
+		migrations = append(migrations, migration)
+	}
+
+	return migrations, nil
+}
+
+// newScriptMigration parses the directives of the scripts.
+// Either script may carry both directions in Up and Down sections, as long as each direction is defined once.
+func newScriptMigration[T Transaction](version int64, upPath, upContent, downPath, downContent string) (*ScriptMigration[T], error) {
+	upScript, err := sqlscript.Parse(upContent, migrate.DirectionUp)
+	if err != nil {
+		return nil, fmt.Errorf("parsing up script: %w", err)
+	}
+
+	downScript, err := sqlscript.Parse(downContent, migrate.DirectionDown)
+	if err != nil {
+		return nil, fmt.Errorf("parsing down script: %w", err)
+	}
+
+	up, upFile, err := mergeSections(migrate.DirectionUp, upScript.Up, upPath, downScript.Up, downPath)
+	if err != nil {
+		return nil, err
+	}
+
+	down, downFile, err := mergeSections(migrate.DirectionDown, upScript.Down, upPath, downScript.Down, downPath)
 	if err != nil {
 		return nil, err
 	}
 
 	migration := &ScriptMigration[T]{
-		version:    version,
-		upScript:   upScript,
-		downScript: downScript,
+		version:        version,
+		upScript:       up.Text,
+		downScript:     down.Text,
+		checksum:       checksum(upContent, downContent),
+		upStatements:   up.Statements,
+		downStatements: down.Statements,
+		upFile:         upFile,
+		downFile:       downFile,
+		noTransaction:  upScript.NoTransaction || downScript.NoTransaction,
 	}
 
 	return migration, nil
 }
 
-func readFileContent(fileSystem fs.FS, filePath string) (string, error) {
+// mergeSections returns the section of the direction defined by either script, and the path of that script.
+func mergeSections(
+	direction migrate.Direction,
+	fromUp sqlscript.Section, upPath string,
+	fromDown sqlscript.Section, downPath string,
+) (sqlscript.Section, string, error) {
+	switch {
+	case strings.TrimSpace(fromDown.Text) == "":
+		return fromUp, upPath, nil
+	case strings.TrimSpace(fromUp.Text) == "":
+		return fromDown, downPath, nil
+	default:
+		return sqlscript.Section{}, "", fmt.Errorf("%w: %s section defined by both scripts", sqlscript.ErrDirective, direction)
+	}
+}
+
+// checksum returns the hex encoded SHA-256 digest of the scripts, including both sections and every directive.
+// Each script is followed by a NUL byte, so that moving content from one script to the other changes the digest.
+func checksum(scripts ...string) string {
+	hash := sha256.New()
+	for _, script := range scripts {
+		hash.Write([]byte(script))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func readFileContent(fileSystem fs.FS, filePath string) (_ string, err error) {
 	file, err := fileSystem.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read file: %w", err)
 	}
 	defer func() {
-		if err := file.Close(); err != nil {
-			fmt.Printf("failed to close file: %v\n", err)
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close file: %w", closeErr)
 		}
 	}()
 
@@ -63,6 +187,7 @@ func readFileContent(fileSystem fs.FS, filePath string) (string, error) {
 }
 
 // NewScriptMigrationFromReader creates a new Migration from readers for up and down scripts.
+// With a nil downReader, the migration is irreversible.
 func NewScriptMigrationFromReader[T Transaction](
 	version int64,
 	upReader io.Reader,
@@ -88,13 +213,7 @@ func NewScriptMigrationFromReader[T Transaction](
 		return nil, err
 	}
 
-	migration := &ScriptMigration[T]{
-		version:    version,
-		upScript:   string(upContent),
-		downScript: string(downContent),
-	}
-
-	return migration, nil
+	return newScriptMigration[T](version, "", string(upContent), "", string(downContent))
 }
 
 func safeReadAll(reader io.Reader) ([]byte, error) {
@@ -119,22 +238,86 @@ func closeReader(reader io.Reader) error {
 	return nil
 }
 
-func (m *ScriptMigration[T]) Up(ctx context.Context, tx *Versioner[T]) error {
-	_, err := tx.Tx.Exec(m.upScript)
-	if err != nil {
+func (m *ScriptMigration[T]) Up(ctx context.Context, tx *Versioner[T]) (err error) {
+	tx.config.logger.DebugContext(ctx, "executing up script", "version", m.version)
+
+	ctx, span := tx.config.startSpan(ctx, "ScriptMigration.Up", "", m.upScript)
+	defer func() { endSpan(span, err) }()
+
+	if err := execStatements(ctx, tx, m.upFile, m.upStatements); err != nil {
 		return fmt.Errorf("failed to apply migration %d: %w", m.version, err)
 	}
 	return nil
 }
 
-func (m *ScriptMigration[T]) Down(ctx context.Context, tx *Versioner[T]) error {
-	_, err := tx.Tx.Exec(m.downScript)
-	if err != nil {
+func (m *ScriptMigration[T]) Down(ctx context.Context, tx *Versioner[T]) (err error) {
+	tx.config.logger.DebugContext(ctx, "executing down script", "version", m.version)
+
+	ctx, span := tx.config.startSpan(ctx, "ScriptMigration.Down", "", m.downScript)
+	defer func() { endSpan(span, err) }()
+
+	if err := execStatements(ctx, tx, m.downFile, m.downStatements); err != nil {
 		return fmt.Errorf("failed to revert migration %d: %w", m.version, err)
 	}
 	return nil
 }
 
+// execStatements executes the statements in order, stopping at the first error.
+// The error is located in the script, using the position reported by PostgreSQL.
+func execStatements[T Transaction](ctx context.Context, tx *Versioner[T], file string, statements []sqlscript.Statement) error {
+	for i, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.SQL); err != nil {
+			return statementError(file, i, statement, err)
+		}
+	}
+	return nil
+}
+
+// statementError locates the error of the statement in its script.
+func statementError(file string, index int, statement sqlscript.Statement, err error) error {
+	line, column := statement.Line, statement.Column
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if position, convErr := strconv.Atoi(pqErr.Position); convErr == nil && position > 0 {
+			line, column = statement.Position(position)
+		}
+	}
+
+	return &sqlscript.StatementError{
+		File:      file,
+		Statement: index + 1,
+		Line:      line,
+		Column:    column,
+		Err:       err,
+	}
+}
+
 func (m *ScriptMigration[T]) Version() int64 {
 	return m.version
 }
+
+// Name returns the description of the migration, parsed from its file names by LoadScriptMigrations.
+// It's empty for migrations created otherwise.
+func (m *ScriptMigration[T]) Name() string {
+	return m.name
+}
+
+// NoTransaction tells if the scripts must run outside a transaction.
+// It's set by a "-- +codemigrate NoTransaction" line in either script.
+// Without a transaction, each statement is committed as it runs, so a failure leaves the earlier statements applied.
+func (m *ScriptMigration[T]) NoTransaction() bool {
+	return m.noTransaction
+}
+
+// Irreversible tells if the migration has no down script, either because it was omitted or is empty.
+// The migrator refuses to revert it, instead of moving the version back without changing the schema.
+func (m *ScriptMigration[T]) Irreversible() bool {
+	return len(m.downStatements) == 0
+}
+
+// Checksum returns the SHA-256 digest of the up and down scripts, directives included.
+// It's stored when the migration is applied, to detect if the script is edited afterwards.
+func (m *ScriptMigration[T]) Checksum() string {
+	return m.checksum
+}
@@ -0,0 +1,139 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+type (
+	// recorder is an executor that records statements instead of running them.
+	recorder struct {
+		statements []string
+	}
+
+	// recordedResult is the result of a recorded statement.
+	recordedResult struct{}
+)
+
+var (
+	_ executor = (*recorder)(nil)
+
+	_ sql.Result = recordedResult{}
+
+	_ migrate.RecordingDatabase[*Versioner[*sql.Tx]] = (*Postgres[*sql.Tx])(nil)
+)
+
+// placeholderPattern matches the positional parameters of a statement, such as $1.
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// SetupStatements returns the statements that create the tables used to keep track of the migrations.
+// The version table gets a row, so that rendered version changes always update it.
+func (p *Postgres[T]) SetupStatements() []string {
+	return append(p.config.schemaQueries(),
+		p.config.upgradeQuery(),
+		fmt.Sprintf("INSERT INTO %[1]s (version) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM %[1]s)", p.config.tableName),
+	)
+}
+
+// Record calls the handler with a versioner that records every statement instead of running it.
+// Queries fail with migrate.ErrNotRenderable, as their results can't be known in advance.
+// Tx is the zero value, so handlers using it directly also fail with migrate.ErrNotRenderable, instead of panicking.
+func (p *Postgres[T]) Record(ctx context.Context, handler func(tx *Versioner[T]) error) (_ []string, err error) {
+	recorder := &recorder{}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: handler panicked while recording: %v", migrate.ErrNotRenderable, r)
+		}
+	}()
+
+	versioner := &Versioner[T]{
+		config:   p.config,
+		executor: recorder,
+		ctx:      ctx,
+	}
+
+	if err := handler(versioner); err != nil {
+		return nil, fmt.Errorf("handler error: %w", err)
+	}
+	return recorder.statements, nil
+}
+
+func (r *recorder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	statement, err := inlineArgs(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	r.statements = append(r.statements, statement)
+	return recordedResult{}, nil
+}
+
+func (r *recorder) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, fmt.Errorf("%w: query %q", migrate.ErrNotRenderable, query)
+}
+
+// LastInsertId isn't supported by PostgreSQL.
+func (recordedResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("%w: last insert id", migrate.ErrNotRenderable)
+}
+
+// RowsAffected assumes statements succeed, changing a single row.
+func (recordedResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+// inlineArgs replaces the positional parameters of the statement by the literal value of its arguments.
+func inlineArgs(query string, args []any) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+
+	literals := make([]string, len(args))
+	for i, arg := range args {
+		value, err := literal(arg)
+		if err != nil {
+			return "", fmt.Errorf("argument $%d: %w", i+1, err)
+		}
+		literals[i] = value
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(query, func(placeholder string) string {
+		index, _ := strconv.Atoi(placeholder[1:])
+		if index < 1 || index > len(literals) {
+			return placeholder
+		}
+		return literals[index-1]
+	}), nil
+}
+
+// literal formats the value as a PostgreSQL literal.
+func literal(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'", nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int32:
+		return strconv.FormatInt(int64(value), 10), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case time.Time:
+		return "'" + value.Format(time.RFC3339Nano) + "'::timestamptz", nil
+	default:
+		return "", fmt.Errorf("%w: unsupported argument type %T", migrate.ErrNotRenderable, value)
+	}
+}
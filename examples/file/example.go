@@ -21,12 +21,10 @@ func Test_Example_Code(t *testing.T) {
 
 	v := adapter.From(conn)
 
-	migration_0001, err := adapter.NewScriptMigrationFromFile[*sql.Tx](1, fs, "0001_init.up.sql", "0001_init.down.sql")
+	migrations, err := adapter.LoadScriptMigrations[*sql.Tx](fs, "migrations")
 	require.NoError(t, err)
 
-	migrator, err := migrate.New(v,
-		migration_0001,
-	)
+	migrator, err := migrate.New(v, migrations...)
 	require.NoError(t, err)
 
 	err = migrator.Up(ctx, migrate.Latest)
@@ -0,0 +1,214 @@
+// Package metrics exports the migration state of a database as Prometheus metrics.
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+type (
+	// StatusReader reads the migration status of a database.
+	// It's implemented by migrate.Migrator.
+	StatusReader interface {
+		Status(ctx context.Context) (*migrate.Status, error)
+	}
+
+	Config struct {
+		namespace     string
+		buckets       []float64
+		statusTimeout time.Duration
+	}
+
+	Option func(*Config)
+
+	// Collector is a Prometheus collector for the migration state of a database.
+	// It's also a migrate.Observer, recording the duration and failures of each migration run by the migrator.
+	//
+	// The schema version and pending migrations gauges are read on every scrape, from the StatusReader set by Watch.
+	// This way, each replica reports whether its database is behind the migrations it ships, even if it doesn't run them.
+	// While the observed migrator is running, scrapes export the last status read instead,
+	// so they don't compete with the migrations for the database. It's read again once the run completes.
+	Collector struct {
+		config Config
+
+		mutex  sync.RWMutex
+		reader StatusReader
+		// status is the last status read, exported while migrations are running.
+		status *migrate.Status
+		// running is set from the first migration of a run until it completes.
+		running bool
+
+		currentVersion *prometheus.Desc
+		pending        *prometheus.Desc
+		unknown        *prometheus.Desc
+		statusErrors   prometheus.Counter
+		duration       *prometheus.HistogramVec
+		failures       *prometheus.CounterVec
+	}
+)
+
+var (
+	_ prometheus.Collector = (*Collector)(nil)
+	_ migrate.Observer     = (*Collector)(nil)
+)
+
+// WithNamespace sets the namespace prefixed to every metric name. It defaults to "codemigrate".
+func WithNamespace(namespace string) Option {
+	return func(c *Config) {
+		c.namespace = namespace
+	}
+}
+
+// WithBuckets sets the buckets of the migration duration histogram, in seconds.
+// It defaults to prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(c *Config) {
+		c.buckets = buckets
+	}
+}
+
+// WithStatusTimeout sets how long a scrape waits for the database status. It defaults to 5 seconds.
+func WithStatusTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.statusTimeout = timeout
+	}
+}
+
+// NewCollector creates a new collector.
+// Register it as an observer of the migrator with migrate.WithObserver, and call Watch to export the database status.
+func NewCollector(opts ...Option) *Collector {
+	config := Config{
+		namespace:     "codemigrate",
+		buckets:       prometheus.DefBuckets,
+		statusTimeout: 5 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return &Collector{
+		config: config,
+		currentVersion: prometheus.NewDesc(
+			prometheus.BuildFQName(config.namespace, "", "schema_version"),
+			"Version stored in the database.",
+			nil, nil,
+		),
+		pending: prometheus.NewDesc(
+			prometheus.BuildFQName(config.namespace, "", "pending_migrations"),
+			"Number of registered migrations not applied to the database.",
+			nil, nil,
+		),
+		unknown: prometheus.NewDesc(
+			prometheus.BuildFQName(config.namespace, "", "unknown_migrations"),
+			"Number of versions applied to the database that match no registered migration.",
+			nil, nil,
+		),
+		statusErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: config.namespace,
+			Name:      "status_errors_total",
+			Help:      "Number of failures reading the database status.",
+		}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: config.namespace,
+			Name:      "migration_duration_seconds",
+			Help:      "Duration of each migration applied or reverted.",
+			Buckets:   config.buckets,
+		}, []string{"version", "direction"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.namespace,
+			Name:      "migration_failures_total",
+			Help:      "Number of failed migrations.",
+		}, []string{"version", "direction"}),
+	}
+}
+
+// Watch sets the reader used to export the database status on every scrape.
+func (c *Collector) Watch(reader StatusReader) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reader = reader
+	c.status = nil
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.currentVersion
+	ch <- c.pending
+	ch <- c.unknown
+	c.statusErrors.Describe(ch)
+	c.duration.Describe(ch)
+	c.failures.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collectStatus(ch)
+	c.statusErrors.Collect(ch)
+	c.duration.Collect(ch)
+	c.failures.Collect(ch)
+}
+
+// collectStatus reads the database status, and exports its gauges.
+// While migrations are running, it exports the last status read, if any.
+// When reading fails, the gauges are omitted and the status errors counter is incremented.
+func (c *Collector) collectStatus(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	reader, status, running := c.reader, c.status, c.running
+	c.mutex.RUnlock()
+
+	if reader == nil {
+		return
+	}
+
+	if !running || status == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.statusTimeout)
+		defer cancel()
+
+		var err error
+		status, err = reader.Status(ctx)
+		if err != nil {
+			c.statusErrors.Inc()
+			return
+		}
+
+		c.mutex.Lock()
+		c.status = status
+		c.mutex.Unlock()
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.currentVersion, prometheus.GaugeValue, float64(status.CurrentVersion))
+	ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(len(status.Pending)))
+	ch <- prometheus.MustNewConstMetric(c.unknown, prometheus.GaugeValue, float64(len(status.Unknown)))
+}
+
+func (c *Collector) BeforeMigration(ctx context.Context, event migrate.Event) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.running = true
+	return nil
+}
+
+func (c *Collector) AfterMigration(ctx context.Context, event migrate.Event) {
+	c.duration.WithLabelValues(labels(event)...).Observe(event.Duration.Seconds())
+}
+
+func (c *Collector) OnError(ctx context.Context, event migrate.Event) {
+	c.failures.WithLabelValues(labels(event)...).Inc()
+}
+
+func (c *Collector) OnComplete(ctx context.Context, event migrate.Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.running = false
+}
+
+func labels(event migrate.Event) []string {
+	return []string{strconv.FormatInt(event.Version, 10), string(event.Direction)}
+}
@@ -0,0 +1,116 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sonalys/codemigrate/metrics"
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+type statusReader func(ctx context.Context) (*migrate.Status, error)
+
+func (f statusReader) Status(ctx context.Context) (*migrate.Status, error) {
+	return f(ctx)
+}
+
+func TestCollector_Status(t *testing.T) {
+	t.Run("success: exports the database status", func(t *testing.T) {
+		collector := metrics.NewCollector()
+		collector.Watch(statusReader(func(ctx context.Context) (*migrate.Status, error) {
+			return &migrate.Status{
+				CurrentVersion: 2,
+				Applied:        []int64{1, 2},
+				Pending:        []int64{3, 4, 5},
+			}, nil
+		}))
+
+		expected := `
+# HELP codemigrate_pending_migrations Number of registered migrations not applied to the database.
+# TYPE codemigrate_pending_migrations gauge
+codemigrate_pending_migrations 3
+# HELP codemigrate_schema_version Version stored in the database.
+# TYPE codemigrate_schema_version gauge
+codemigrate_schema_version 2
+`
+		err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+			"codemigrate_pending_migrations",
+			"codemigrate_schema_version",
+		)
+		require.NoError(t, err)
+	})
+
+	t.Run("success: exports the last status while migrating", func(t *testing.T) {
+		ctx := t.Context()
+		collector := metrics.NewCollector()
+
+		reads := 0
+		collector.Watch(statusReader(func(ctx context.Context) (*migrate.Status, error) {
+			reads++
+			return &migrate.Status{CurrentVersion: int64(reads)}, nil
+		}))
+
+		require.NoError(t, collector.BeforeMigration(ctx, migrate.Event{Version: 2, Direction: migrate.DirectionUp}))
+
+		require.Equal(t, 1, testutil.CollectAndCount(collector, "codemigrate_schema_version"))
+		require.Equal(t, 1, testutil.CollectAndCount(collector, "codemigrate_schema_version"))
+		require.Equal(t, 1, reads)
+
+		collector.OnComplete(ctx, migrate.Event{Version: 2, Direction: migrate.DirectionUp})
+
+		expected := `
+# HELP codemigrate_schema_version Version stored in the database.
+# TYPE codemigrate_schema_version gauge
+codemigrate_schema_version 2
+`
+		err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "codemigrate_schema_version")
+		require.NoError(t, err)
+		require.Equal(t, 2, reads)
+	})
+
+	t.Run("success: nothing is exported without a reader", func(t *testing.T) {
+		collector := metrics.NewCollector()
+		require.Equal(t, 0, testutil.CollectAndCount(collector, "codemigrate_schema_version"))
+	})
+
+	t.Run("error: counts status failures", func(t *testing.T) {
+		collector := metrics.NewCollector(metrics.WithNamespace("app"))
+		collector.Watch(statusReader(func(ctx context.Context) (*migrate.Status, error) {
+			return nil, errors.New("connection refused")
+		}))
+
+		require.Equal(t, 0, testutil.CollectAndCount(collector, "app_schema_version"))
+
+		expected := `
+# HELP app_status_errors_total Number of failures reading the database status.
+# TYPE app_status_errors_total counter
+app_status_errors_total 2
+`
+		err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "app_status_errors_total")
+		require.NoError(t, err)
+	})
+}
+
+func TestCollector_Observer(t *testing.T) {
+	ctx := t.Context()
+	collector := metrics.NewCollector()
+
+	collector.AfterMigration(ctx, migrate.Event{Version: 1, Direction: migrate.DirectionUp, Duration: time.Second})
+	collector.AfterMigration(ctx, migrate.Event{Version: 2, Direction: migrate.DirectionUp, Duration: time.Second})
+	collector.OnError(ctx, migrate.Event{Version: 3, Direction: migrate.DirectionUp, Err: errors.New("syntax error")})
+
+	require.Equal(t, 2, testutil.CollectAndCount(collector, "codemigrate_migration_duration_seconds"))
+
+	expected := `
+# HELP codemigrate_migration_failures_total Number of failed migrations.
+# TYPE codemigrate_migration_failures_total counter
+codemigrate_migration_failures_total{direction="up",version="3"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "codemigrate_migration_failures_total")
+	require.NoError(t, err)
+}
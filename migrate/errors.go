@@ -1,7 +1,33 @@
 package migrate
 
+import (
+	"errors"
+	"fmt"
+)
+
 type StringError string
 
+// Phase is the part of a migration step that failed, reported by MigrationError.
+type Phase string
+
+const (
+	// PhaseBegin when the transaction, or the connection running a migration outside one, couldn't be started.
+	PhaseBegin Phase = "begin"
+	// PhaseVersionRead when the current version and the state of the database couldn't be read.
+	PhaseVersionRead Phase = "version read"
+	// PhaseMigration when the Up or Down method of the migration failed.
+	PhaseMigration Phase = "migration body"
+	// PhaseVersionWrite when the new version, or the bookkeeping around it, couldn't be stored.
+	PhaseVersionWrite Phase = "version write"
+	// PhaseCommit when the transaction couldn't be committed.
+	PhaseCommit Phase = "commit"
+)
+
+// SQLStater is implemented by database errors carrying a SQLSTATE code, such as *pq.Error and *pgconn.PgError.
+type SQLStater interface {
+	SQLState() string
+}
+
 const (
 	// ErrNoMigrations when no migrations were applied.
 	ErrNoMigrations = StringError("no migrations applied")
@@ -9,12 +35,178 @@ const (
 	ErrMigrationNotFound = StringError("migration not found")
 	// ErrDuplicateMigration when a version is duplicated.
 	ErrDuplicateMigration = StringError("duplicate migration version")
+	// ErrHistoryNotSupported when the versioner doesn't keep a migration history.
+	ErrHistoryNotSupported = StringError("versioner doesn't support history")
+	// ErrAppliedVersionsNotSupported when the versioner doesn't track applied versions.
+	ErrAppliedVersionsNotSupported = StringError("versioner doesn't support applied versions")
+	// ErrOutOfOrder when migrations older than the current version were never applied.
+	ErrOutOfOrder = StringError("migrations out of order")
+	// ErrChecksumsNotSupported when the versioner doesn't store checksums.
+	ErrChecksumsNotSupported = StringError("versioner doesn't support checksums")
+	// ErrChecksumMismatch when an applied migration was edited.
+	ErrChecksumMismatch = StringError("checksum mismatch")
+	// ErrDirtyNotSupported when the versioner can't flag dirty versions.
+	ErrDirtyNotSupported = StringError("versioner doesn't support dirty versions")
+	// ErrNoTransactionNotSupported when the database can't run migrations outside a transaction.
+	ErrNoTransactionNotSupported = StringError("database doesn't support migrations outside a transaction")
+	// ErrNoTransaction when a migration running outside a transaction uses the transaction of its versioner.
+	ErrNoTransaction = StringError("migration isn't running in a transaction")
+	// ErrDirty when a migration was left partially applied, and the database must be repaired with Force.
+	ErrDirty = StringError("database is dirty")
+	// ErrVetoed when an observer refuses a migration from BeforeMigration.
+	ErrVetoed = StringError("migration vetoed")
+	// ErrIrreversible when a migration can't be reverted.
+	// Migrations can return it from Down, or implement Irreversible.
+	ErrIrreversible = StringError("migration is irreversible")
+	// ErrInvalidDirection when the direction is neither DirectionUp nor DirectionDown.
+	ErrInvalidDirection = StringError("invalid direction")
+	// ErrNoTransactionDryRun when a dry run reaches a migration that can't run inside a transaction.
+	ErrNoTransactionDryRun = StringError("migration outside a transaction can't be dry run")
+	// ErrRenderNotSupported when the database can't record statements.
+	ErrRenderNotSupported = StringError("database doesn't support rendering migrations")
+	// ErrNotRenderable when a migration reads from the database, so its statements can't be known in advance.
+	ErrNotRenderable = StringError("migration can't be rendered")
+	// ErrInvalidScripts when the migration scripts found by FindScripts are inconsistent.
+	ErrInvalidScripts = StringError("invalid migration scripts")
+	// ErrScriptName when a script name doesn't follow the NNNN_description.up.sql, NNNN_description.down.sql or NNNN_description.sql pattern.
+	ErrScriptName = StringError("script name doesn't match NNNN_description.up.sql, NNNN_description.down.sql or NNNN_description.sql")
+	// ErrOrphanedScript when a down script has no matching up script.
+	ErrOrphanedScript = StringError("down script without up script")
+	// ErrMismatchedScripts when the up and down scripts of a version have different descriptions.
+	ErrMismatchedScripts = StringError("up and down scripts have different descriptions")
+	// ErrMigrationName when the name of a new migration has no letters or digits.
+	ErrMigrationName = StringError("migration name must have letters or digits")
+	// ErrLockTimeout when the migration lock couldn't be acquired in time.
+	ErrLockTimeout = StringError("timeout acquiring migration lock")
+	// ErrLeaseLost when the lease held by a LeaseLocker expired, and was taken by another owner.
+	ErrLeaseLost = StringError("migration lease lost")
 )
 
+// OutOfOrderError is returned by Up, when using WithStrictOrder,
+// if registered migrations older than the current version were never applied.
+type OutOfOrderError struct {
+	// Versions lists the skipped versions, in ascending order.
+	Versions []int64
+}
+
+// ChecksumMismatchError is returned by Validate when applied migrations were edited after being applied.
+type ChecksumMismatchError struct {
+	// Versions lists the edited versions, in ascending order.
+	Versions []int64
+}
+
+// DirtyError is returned by Up and Down when a migration was left partially applied.
+// After repairing the database by hand, use Force to store the correct version and clear the flag.
+type DirtyError struct {
+	// Version is the version of the partially applied migration.
+	Version int64
+}
+
+// IrreversibleError is returned by Down when it reaches a migration that can't be reverted.
+// The migrations before it are reverted, and the database stays at its version.
+// Use WithIrreversibleOverride to move past it anyway.
+type IrreversibleError struct {
+	// Version is the version of the irreversible migration.
+	Version int64
+}
+
+// MigrationError is returned when a step of Up, Down, or the methods built on them, fails.
+// Use errors.As to tell which migration failed, and in which phase.
+type MigrationError struct {
+	// Version is the version of the failed migration.
+	// When the step failed before choosing a migration, it's the last known version of the database.
+	Version int64
+	// Direction tells if the migration was being applied or reverted.
+	Direction Direction
+	// Phase is the part of the step that failed.
+	Phase Phase
+	// SQLState is the SQLSTATE code of the database error, if the error carries one.
+	SQLState string
+	// Err is the underlying error.
+	Err error
+}
+
+// NotRenderableError is returned by Render when migrations read from the database.
+type NotRenderableError struct {
+	// Versions lists the migrations that can't be rendered, in the order they would run.
+	Versions []int64
+}
+
 var (
 	_ error = StringError("")
+	_ error = &OutOfOrderError{}
+	_ error = &ChecksumMismatchError{}
+	_ error = &DirtyError{}
+	_ error = &IrreversibleError{}
+	_ error = &NotRenderableError{}
+	_ error = &MigrationError{}
 )
 
 func (e StringError) Error() string {
 	return string(e)
 }
+
+func (e *OutOfOrderError) Error() string {
+	return fmt.Sprintf("%s: versions %v are older than the current version and were never applied", ErrOutOfOrder, e.Versions)
+}
+
+func (e *OutOfOrderError) Unwrap() error {
+	return ErrOutOfOrder
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s: versions %v were edited after being applied", ErrChecksumMismatch, e.Versions)
+}
+
+func (e *ChecksumMismatchError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("%s: migration %d was left partially applied, repair it and call Force", ErrDirty, e.Version)
+}
+
+func (e *DirtyError) Unwrap() error {
+	return ErrDirty
+}
+
+func (e *IrreversibleError) Error() string {
+	return fmt.Sprintf("%s: migration %d can't be reverted", ErrIrreversible, e.Version)
+}
+
+func (e *IrreversibleError) Unwrap() error {
+	return ErrIrreversible
+}
+
+func (e *NotRenderableError) Error() string {
+	return fmt.Sprintf("%s: versions %v read from the database", ErrNotRenderable, e.Versions)
+}
+
+func (e *NotRenderableError) Unwrap() error {
+	return ErrNotRenderable
+}
+
+// newMigrationError wraps the error of a migration step, extracting its SQLSTATE code, if any.
+func newMigrationError(version int64, direction Direction, phase Phase, err error) *MigrationError {
+	migrationErr := &MigrationError{
+		Version:   version,
+		Direction: direction,
+		Phase:     phase,
+		Err:       err,
+	}
+
+	var stater SQLStater
+	if errors.As(err, &stater) {
+		migrationErr.SQLState = stater.SQLState()
+	}
+
+	return migrationErr
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("%s of migration %d failed in %s: %s", e.Direction, e.Version, e.Phase, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
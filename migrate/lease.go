@@ -0,0 +1,206 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// Lease is an expiring lock stored in the database. It's used by LeaseLocker.
+	// The lease is held by a single owner at a time, and expires after its ttl unless renewed.
+	Lease interface {
+		// AcquireLease takes the lease for the owner, if it's free, expired, or already held by the owner.
+		// It returns false if another owner holds the lease.
+		AcquireLease(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+		// RenewLease extends the lease held by the owner.
+		// It returns false if the owner doesn't hold the lease anymore.
+		RenewLease(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+		// ReleaseLease frees the lease, if it's held by the owner.
+		ReleaseLease(ctx context.Context, owner string) error
+	}
+
+	// LeaseLocker is a Locker backed by a Lease, for databases without native locks.
+	// While it's locked, a heartbeat renews the lease, so a crashed process holds it for one ttl at most.
+	// It's created by NewLeaseLocker.
+	LeaseLocker struct {
+		lease Lease
+		ttl   time.Duration
+		owner string
+
+		// held is filled while a caller holds the lease, so that other callers of this locker wait.
+		held  chan struct{}
+		mutex sync.Mutex
+		stop  context.CancelFunc
+		done  chan struct{}
+		// lost is set by the heartbeat when the lease is lost. It wraps ErrLeaseLost.
+		lost error
+	}
+)
+
+var (
+	_ ExpiringLocker = (*LeaseLocker)(nil)
+)
+
+// NewLeaseLocker creates a Locker that holds the given lease, renewing it while locked.
+// The ttl is how long the lease survives without a heartbeat. It's renewed every third of the ttl,
+// so it must be greater than 0.
+func NewLeaseLocker(lease Lease, ttl time.Duration) (*LeaseLocker, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lease ttl must be greater than 0, got %s", ttl)
+	}
+
+	return &LeaseLocker{
+		lease: lease,
+		ttl:   ttl,
+		owner: newLeaseOwner(),
+		held:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Owner returns the identifier of the locker, stored as the lease owner.
+func (l *LeaseLocker) Owner() string {
+	return l.owner
+}
+
+// Lock polls the lease until it's acquired, or the context is canceled.
+// Then it starts the heartbeat, until Unlock is called.
+func (l *LeaseLocker) Lock(ctx context.Context) error {
+	_, err := l.LockContext(ctx)
+	return err
+}
+
+// LockContext acquires the lease like Lock, and returns a context canceled with ErrLeaseLost
+// as its cause if the lease is lost before Unlock is called.
+// Callers sharing the locker wait for each other, as they share the lease owner.
+func (l *LeaseLocker) LockContext(ctx context.Context) (_ context.Context, err error) {
+	select {
+	case l.held <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("acquiring lease: %w", ctx.Err())
+	}
+	defer func() {
+		if err != nil {
+			<-l.held
+		}
+	}()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ticker := time.NewTicker(l.ttl / 4)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := l.lease.AcquireLease(ctx, l.owner, l.ttl)
+		if err != nil {
+			return nil, fmt.Errorf("acquiring lease: %w", err)
+		}
+
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("acquiring lease: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	heartbeatCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	lockCtx, cancel := context.WithCancelCause(ctx)
+
+	l.stop = func() {
+		stop()
+		cancel(nil)
+	}
+	l.done = make(chan struct{})
+	l.lost = nil
+
+	go l.heartbeat(heartbeatCtx, cancel)
+
+	return lockCtx, nil
+}
+
+// Unlock stops the heartbeat and releases the lease.
+// It returns ErrLeaseLost if the lease expired while it was locked.
+func (l *LeaseLocker) Unlock(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stop == nil {
+		return nil
+	}
+	defer func() { <-l.held }()
+
+	l.stop()
+	<-l.done
+	l.stop = nil
+
+	if l.lost != nil {
+		return l.lost
+	}
+
+	if err := l.lease.ReleaseLease(ctx, l.owner); err != nil {
+		return fmt.Errorf("releasing lease: %w", err)
+	}
+
+	return nil
+}
+
+// heartbeat renews the lease until the context is canceled, or the lease is lost.
+// Failed renewals are retried on the next beat, as the lease survives until its ttl.
+// Once the ttl passes without a renewal, the lease is considered lost.
+// A lost lease cancels the context returned by LockContext.
+func (l *LeaseLocker) heartbeat(ctx context.Context, cancel context.CancelCauseFunc) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed, err := l.lease.RenewLease(ctx, l.owner, l.ttl)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil && renewed:
+			renewedAt = time.Now()
+			continue
+		case err == nil:
+			l.lost = ErrLeaseLost
+		case time.Since(renewedAt) < l.ttl:
+			continue
+		default:
+			l.lost = fmt.Errorf("%w: renewing lease: %w", ErrLeaseLost, err)
+		}
+
+		cancel(l.lost)
+		return
+	}
+}
+
+// newLeaseOwner identifies the process holding a lease.
+func newLeaseOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
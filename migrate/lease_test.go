@@ -0,0 +1,227 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLease is a Lease stored in memory.
+type memoryLease struct {
+	mutex     sync.Mutex
+	owner     string
+	expiresAt time.Time
+	renewals  int
+	renewErr  error
+}
+
+func (l *memoryLease) AcquireLease(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.owner != "" && l.owner != owner && time.Now().Before(l.expiresAt) {
+		return false, nil
+	}
+
+	l.owner = owner
+	l.expiresAt = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memoryLease) RenewLease(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.renewErr != nil {
+		return false, l.renewErr
+	}
+
+	if l.owner != owner {
+		return false, nil
+	}
+
+	l.expiresAt = time.Now().Add(ttl)
+	l.renewals++
+	return true, nil
+}
+
+func (l *memoryLease) ReleaseLease(ctx context.Context, owner string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.owner == owner {
+		l.owner = ""
+	}
+	return nil
+}
+
+func (l *memoryLease) holder() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.owner
+}
+
+func (l *memoryLease) renewed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.renewals > 0
+}
+
+func (l *memoryLease) steal(owner string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.owner = owner
+}
+
+func (l *memoryLease) failRenewals(err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.renewErr = err
+}
+
+func newLeaseLocker(t *testing.T, lease migrate.Lease, ttl time.Duration) *migrate.LeaseLocker {
+	locker, err := migrate.NewLeaseLocker(lease, ttl)
+	require.NoError(t, err)
+	return locker
+}
+
+func TestLeaseLocker(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+
+	t.Run("success: lock and unlock", func(t *testing.T) {
+		ctx := t.Context()
+		lease := &memoryLease{}
+		locker := newLeaseLocker(t, lease, ttl)
+
+		require.NoError(t, locker.Lock(ctx))
+		require.Equal(t, locker.Owner(), lease.holder())
+
+		require.NoError(t, locker.Unlock(ctx))
+		require.Empty(t, lease.holder())
+	})
+
+	t.Run("success: heartbeat keeps the lease", func(t *testing.T) {
+		ctx := t.Context()
+		lease := &memoryLease{}
+		owner := newLeaseLocker(t, lease, ttl)
+		other := newLeaseLocker(t, lease, ttl)
+
+		require.NoError(t, owner.Lock(ctx))
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*ttl)
+		defer cancel()
+
+		err := other.Lock(timeoutCtx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.True(t, lease.renewed())
+
+		require.NoError(t, owner.Unlock(ctx))
+		require.NoError(t, other.Lock(ctx))
+		require.NoError(t, other.Unlock(ctx))
+	})
+
+	t.Run("success: callers of the same locker wait for each other", func(t *testing.T) {
+		ctx := t.Context()
+		locker := newLeaseLocker(t, &memoryLease{}, ttl)
+
+		require.NoError(t, locker.Lock(ctx))
+
+		acquired := make(chan error, 1)
+		go func() {
+			acquired <- locker.Lock(ctx)
+		}()
+
+		select {
+		case err := <-acquired:
+			t.Fatalf("second caller acquired a held lock: %v", err)
+		case <-time.After(2 * ttl):
+		}
+
+		require.NoError(t, locker.Unlock(ctx))
+		require.NoError(t, <-acquired)
+		require.NoError(t, locker.Unlock(ctx))
+	})
+
+	t.Run("success: expired lease is taken over", func(t *testing.T) {
+		ctx := t.Context()
+		lease := &memoryLease{}
+
+		// A crashed process leaves its lease behind, without a heartbeat.
+		acquired, err := lease.AcquireLease(ctx, "crashed", ttl)
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		locker := newLeaseLocker(t, lease, ttl)
+		require.NoError(t, locker.Lock(ctx))
+		require.Equal(t, locker.Owner(), lease.holder())
+		require.NoError(t, locker.Unlock(ctx))
+	})
+
+	t.Run("error: ttl must be positive", func(t *testing.T) {
+		locker, err := migrate.NewLeaseLocker(&memoryLease{}, 0)
+		require.Error(t, err)
+		require.Nil(t, locker)
+	})
+
+	t.Run("error: lease lost while locked", func(t *testing.T) {
+		ctx := t.Context()
+		lease := &memoryLease{}
+		locker := newLeaseLocker(t, lease, ttl)
+
+		require.NoError(t, locker.Lock(ctx))
+		lease.steal("other")
+		time.Sleep(ttl)
+
+		err := locker.Unlock(ctx)
+		require.ErrorIs(t, err, migrate.ErrLeaseLost)
+	})
+	t.Run("error: lost lease cancels the lock context", func(t *testing.T) {
+		ctx := t.Context()
+		lease := &memoryLease{}
+		locker := newLeaseLocker(t, lease, ttl)
+
+		lockCtx, err := locker.LockContext(ctx)
+		require.NoError(t, err)
+
+		lease.steal("other")
+
+		select {
+		case <-lockCtx.Done():
+		case <-time.After(3 * ttl):
+			t.Fatal("lock context wasn't canceled")
+		}
+		require.ErrorIs(t, context.Cause(lockCtx), migrate.ErrLeaseLost)
+		require.ErrorIs(t, locker.Unlock(ctx), migrate.ErrLeaseLost)
+	})
+
+	t.Run("error: failed renewals lose the lease after the ttl", func(t *testing.T) {
+		ctx := t.Context()
+		lease := &memoryLease{}
+		locker := newLeaseLocker(t, lease, ttl)
+
+		lockCtx, err := locker.LockContext(ctx)
+		require.NoError(t, err)
+
+		renewErr := errors.New("connection reset")
+		lease.failRenewals(renewErr)
+
+		select {
+		case <-lockCtx.Done():
+		case <-time.After(3 * ttl):
+			t.Fatal("lock context wasn't canceled")
+		}
+
+		err = locker.Unlock(ctx)
+		require.ErrorIs(t, err, migrate.ErrLeaseLost)
+		require.ErrorIs(t, err, renewErr)
+	})
+}
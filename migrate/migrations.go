@@ -2,136 +2,1239 @@ package migrate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
 	migrator[T Versioner] struct {
 		conn       Database[T]
 		migrations []Migration[T]
+		config     Config
+	}
+
+	// state is the migration state stored in the database.
+	state struct {
+		currentVersion int64
+		// applied is nil when the versioner doesn't implement AppliedVersioner.
+		applied map[int64]bool
+		// unmarked lists the versions applied before they were tracked, that weren't stored, as backfill was disabled.
+		unmarked []int64
+		// dirty is only set when the versioner implements DirtyVersioner.
+		dirty        bool
+		dirtyVersion int64
+	}
+
+	// step is a single migration to be applied or reverted.
+	step[T Versioner] struct {
+		migration Migration[T]
+		direction Direction
+		// nextVersion is the version stored after the step runs.
+		nextVersion int64
+		// skipped is set when an irreversible migration is moved past, with WithIrreversibleOverride.
+		skipped bool
 	}
 )
 
-func (m migrator[T]) handler(ctx context.Context, targetVersion int64, h func(currentVersion int64, tx T) (bool, int64, error)) error {
-	db := m.conn
-	lastAppliedVersion := int64(-1)
+// errDryRun rolls back the transaction of a dry run.
+var errDryRun = errors.New("dry run")
+
+// handler runs the migrations towards the target version, limited to the given number of steps, if positive.
+func (m migrator[T]) handler(ctx context.Context, direction Direction, targetVersion int64, steps int) (*Report, error) {
+	logger := m.config.logger.With("direction", direction, "target_version", targetVersion)
+	if steps > 0 {
+		logger = logger.With("steps", steps)
+	}
+	logger.InfoContext(ctx, "starting migrations")
+
+	ctx, span := m.startProcessSpan(ctx, direction, targetVersion, steps)
+
+	report := &Report{
+		Direction:     direction,
+		TargetVersion: targetVersion,
+		StartedAt:     time.Now(),
+	}
+
+	startedAt := report.StartedAt
+	version, err := m.lockedRun(ctx, direction, targetVersion, steps, report)
+	report.FinalVersion = version
+	report.Duration = time.Since(startedAt)
+	span.SetAttributes(AttributeVersion.Int64(version))
+	endSpan(span, err)
+	if err != nil {
+		logger.ErrorContext(ctx, "migrations failed", "version", version, "duration", time.Since(startedAt), "error", err)
+	} else {
+		logger.InfoContext(ctx, "migrations finished", "version", version, "duration", time.Since(startedAt))
+	}
+
+	event := Event{
+		Version:   version,
+		Direction: direction,
+		Duration:  time.Since(startedAt),
+		Err:       err,
+	}
+
+	for _, observer := range m.config.observers {
+		observer.OnComplete(ctx, event)
+	}
+
+	return report, err
+}
+
+// lockedRun calls run while holding the migration lock, if any.
+func (m migrator[T]) lockedRun(ctx context.Context, direction Direction, targetVersion int64, steps int, report *Report) (version int64, err error) {
+	err = m.withLock(ctx, func(ctx context.Context) error {
+		var err error
+		version, err = m.run(ctx, direction, targetVersion, steps, report)
+		return err
+	})
+	return version, err
+}
+
+// transaction runs the handler in a transaction.
+// Failures outside the handler are wrapped by fail, as the begin or commit phase.
+func (m migrator[T]) transaction(ctx context.Context, fail func(phase Phase, err error) error, handler func(tx T) error) error {
+	var entered, failed bool
+
+	err := m.conn.Transaction(ctx, func(tx T) error {
+		entered = true
+		err := handler(tx)
+		failed = err != nil
+		return err
+	})
+
+	switch {
+	case err == nil || failed:
+		return err
+	case !entered:
+		return fail(PhaseBegin, err)
+	default:
+		return fail(PhaseCommit, err)
+	}
+}
+
+// readTransaction runs the handler in a read-only transaction, if the database supports it,
+// or in a regular transaction otherwise.
+func (m migrator[T]) readTransaction(ctx context.Context, handler func(tx T) error) error {
+	if db, ok := any(m.conn).(ReadOnlyDatabase[T]); ok {
+		return db.ReadTransaction(ctx, handler)
+	}
+	return m.conn.Transaction(ctx, handler)
+}
+
+// lockHeldKey marks the context of a handler called by withLock.
+type lockHeldKey struct{}
+
+// withLock calls the handler while holding the migration lock, if any.
+// The handler receives a context canceled if the lock is lost, when the locker is an ExpiringLocker.
+// Handlers already holding the lock call nested handlers directly.
+func (m migrator[T]) withLock(ctx context.Context, handler func(ctx context.Context) error) (err error) {
+	locker := m.locker()
+	if locker == nil || ctx.Value(lockHeldKey{}) != nil {
+		return handler(ctx)
+	}
+
+	lockCtx := ctx
+	if expiring, ok := locker.(ExpiringLocker); ok {
+		lockCtx, err = expiring.LockContext(ctx)
+	} else {
+		err = locker.Lock(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("acquiring lock: %w", err)
+	}
+	m.config.logger.DebugContext(ctx, "lock acquired")
+
+	defer func() {
+		// The lock must be released even if the process was canceled.
+		if unlockErr := locker.Unlock(context.WithoutCancel(ctx)); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("releasing lock: %w", unlockErr))
+			return
+		}
+		m.config.logger.DebugContext(ctx, "lock released")
+	}()
+
+	return handler(context.WithValue(lockCtx, lockHeldKey{}, true))
+}
+
+// locker returns the lock set by WithLocker, or the database itself if it implements Locker.
+func (m migrator[T]) locker() Locker {
+	if m.config.locker != nil {
+		return m.config.locker
+	}
+
+	if locker, ok := any(m.conn).(Locker); ok {
+		return locker
+	}
+
+	return nil
+}
 
+// run applies or reverts one migration per transaction, until the target version is reached,
+// or the number of steps ran, if positive.
+// It returns the last known version of the database, and records each step in the report.
+func (m migrator[T]) run(ctx context.Context, direction Direction, targetVersion int64, steps int, report *Report) (int64, error) {
 	if len(m.migrations) == 0 {
-		return ErrNoMigrations
+		return 0, ErrNoMigrations
+	}
+
+	if m.config.validateChecksums {
+		if err := m.Validate(ctx); err != nil {
+			return 0, err
+		}
 	}
 
-	for runAgain := true; runAgain; {
-		runAgain = false
+	var version int64
 
-		err := db.Transaction(ctx, func(tx T) error {
-			currentVersion, err := tx.GetCurrentVersion(ctx)
+	for ran := 0; steps <= 0 || ran < steps; ran++ {
+		var (
+			next      *step[T]
+			current   *state
+			startedAt time.Time
+			stepCtx   context.Context
+			span      trace.Span
+		)
+
+		// fail wraps the errors of the step, attributing them to the current migration, once chosen.
+		fail := func(phase Phase, err error) error {
+			if next != nil {
+				return next.fail(phase, err)
+			}
+			return newMigrationError(version, direction, phase, err)
+		}
+
+		err := m.transaction(ctx, fail, func(tx T) error {
+			state, err := m.readState(ctx, tx, true)
 			if err != nil {
-				return fmt.Errorf("getting current version: %w", err)
+				return fail(PhaseVersionRead, err)
 			}
 
-			if currentVersion == targetVersion {
-				lastAppliedVersion = currentVersion
-				return nil
+			current = state
+			version = state.currentVersion
+			m.config.logger.DebugContext(ctx, "read current version", "version", version)
+
+			if ran == 0 {
+				report.InitialVersion = version
 			}
 
-			var nextVersion int64
-			runAgain, nextVersion, err = h(currentVersion, tx)
-			if err != nil {
-				return fmt.Errorf("running handler: %w", err)
+			if state.dirty {
+				return &DirtyError{Version: state.dirtyVersion}
 			}
 
-			err = tx.SetVersion(ctx, nextVersion)
+			next, err = m.nextStep(state, direction, targetVersion)
 			if err != nil {
-				return fmt.Errorf("setting new version: %w", err)
+				return err
 			}
 
-			lastAppliedVersion = nextVersion
-			return nil
+			if next == nil {
+				return nil
+			}
+
+			startedAt = time.Now()
+			stepCtx, span = m.startStepSpan(ctx, next)
+
+			if err := m.beforeMigration(stepCtx, next); err != nil {
+				return err
+			}
+
+			if isNonTransactional(next.migration) {
+				// The dirty flag is committed before the migration runs outside the transaction.
+				return m.setDirty(stepCtx, tx, next)
+			}
+
+			return m.runStep(stepCtx, tx, next)
 		})
+		if err == nil && next != nil && isNonTransactional(next.migration) {
+			err = m.runNonTransactional(stepCtx, next, startedAt)
+		}
+
+		if span != nil {
+			endSpan(span, err)
+		}
+
 		if err != nil {
-			return fmt.Errorf("migration failed: %w", err)
+			if next != nil {
+				m.onError(ctx, next, startedAt, err)
+				report.Migrations = append(report.Migrations, next.report(startedAt, err))
+			}
+			if current != nil {
+				report.Pending = m.pending(current, direction, targetVersion, next, steps-ran)
+			}
+			return version, fmt.Errorf("migration failed: %w", err)
 		}
+
+		if next == nil {
+			break
+		}
+
+		version = next.nextVersion
+		report.Migrations = append(report.Migrations, next.report(startedAt, nil))
+		m.afterMigration(ctx, next, startedAt)
 	}
 
-	if lastAppliedVersion == -1 {
+	return version, nil
+}
+
+func (m migrator[T]) Up(ctx context.Context, targetVersion int64) error {
+	if len(m.migrations) == 0 {
 		return ErrNoMigrations
 	}
 
+	if _, err := m.handler(ctx, DirectionUp, m.resolveTarget(targetVersion), 0); err != nil {
+		return fmt.Errorf("upgrade failed: %w", err)
+	}
+
 	return nil
 }
 
-func (m migrator[T]) Up(ctx context.Context, targetVersion int64) error {
+func (m migrator[T]) Down(ctx context.Context, targetVersion int64) error {
+	if len(m.migrations) == 0 {
+		return ErrNoMigrations
+	}
+
+	if _, err := m.handler(ctx, DirectionDown, m.resolveTarget(targetVersion), 0); err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m migrator[T]) Steps(ctx context.Context, n int) error {
+	if len(m.migrations) == 0 {
+		return ErrNoMigrations
+	}
+
+	switch {
+	case n > 0:
+		if _, err := m.handler(ctx, DirectionUp, m.migrations[len(m.migrations)-1].Version(), n); err != nil {
+			return fmt.Errorf("upgrade failed: %w", err)
+		}
+	case n < 0:
+		if _, err := m.handler(ctx, DirectionDown, Zero, -n); err != nil {
+			return fmt.Errorf("rollback failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (m migrator[T]) Goto(ctx context.Context, version int64) (*Report, error) {
+	report := &Report{TargetVersion: version}
+
+	if version != Zero && m.findMigration(version) == -1 {
+		return report, fmt.Errorf("going to version %d: %w", version, ErrMigrationNotFound)
+	}
+
+	// The direction is chosen under the same lock as the run, so no other process migrates in between.
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		var currentVersion int64
+
+		err := m.readTransaction(ctx, func(tx T) error {
+			var err error
+			currentVersion, err = tx.GetCurrentVersion(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("getting current version: %w", err)
+		}
+
+		report.InitialVersion, report.FinalVersion = currentVersion, currentVersion
+
+		switch {
+		case version > currentVersion:
+			report, err = m.Run(ctx, DirectionUp, version)
+		case version < currentVersion:
+			report, err = m.Run(ctx, DirectionDown, version)
+		}
+		return err
+	})
+
+	return report, err
+}
+
+func (m migrator[T]) Redo(ctx context.Context) error {
 	if len(m.migrations) == 0 {
 		return ErrNoMigrations
 	}
 
-	if targetVersion == Latest {
-		targetVersion = m.migrations[len(m.migrations)-1].Version()
+	// Both steps run under the same lock, so no other process migrates between them.
+	return m.withLock(ctx, func(ctx context.Context) error {
+		report, err := m.handler(ctx, DirectionDown, Zero, 1)
+		if err != nil {
+			return fmt.Errorf("rollback failed: %w", err)
+		}
+
+		if len(report.Migrations) == 0 {
+			return ErrNoMigrations
+		}
+
+		reverted := report.Migrations[0].Version
+
+		// Only the reverted migration is registered, so that no other pending migration is applied, such as with WithOutOfOrder.
+		redo := m
+		redo.migrations = []Migration[T]{m.migrations[m.findMigration(reverted)]}
+
+		if _, err := redo.handler(ctx, DirectionUp, reverted, 1); err != nil {
+			return fmt.Errorf("upgrade failed: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// checkDirection refuses directions other than DirectionUp and DirectionDown,
+// so that a typo doesn't revert migrations.
+func checkDirection(direction Direction) error {
+	if direction != DirectionUp && direction != DirectionDown {
+		return fmt.Errorf("%w: %q", ErrInvalidDirection, direction)
+	}
+	return nil
+}
+
+// resolveTarget replaces Latest and Oldest by the version of the newest and oldest migrations.
+func (m migrator[T]) resolveTarget(targetVersion int64) int64 {
+	switch targetVersion {
+	case Latest:
+		return m.migrations[len(m.migrations)-1].Version()
+	case Oldest:
+		return m.migrations[0].Version()
+	default:
+		return targetVersion
+	}
+}
+
+func (m migrator[T]) Plan(ctx context.Context, direction Direction, targetVersion int64) ([]PlannedMigration, error) {
+	plan, _, err := m.plan(ctx, direction, targetVersion)
+	return plan, err
+}
+
+// plan returns the plan of Plan, alongside the versions applied before they were tracked,
+// that the plan assumes are stored.
+func (m migrator[T]) plan(ctx context.Context, direction Direction, targetVersion int64) (_ []PlannedMigration, unmarked []int64, _ error) {
+	if err := checkDirection(direction); err != nil {
+		return nil, nil, err
+	}
+
+	if len(m.migrations) == 0 {
+		return nil, nil, ErrNoMigrations
+	}
+
+	targetVersion = m.resolveTarget(targetVersion)
+
+	if m.config.validateChecksums {
+		if err := m.Validate(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var current *state
+
+	err := m.readTransaction(ctx, func(tx T) error {
+		var err error
+		current, err = m.readState(ctx, tx, false)
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("planning failed: %w", err)
+	}
+
+	if current.dirty {
+		return nil, nil, &DirtyError{Version: current.dirtyVersion}
+	}
+
+	plan, err := m.simulate(current, direction, targetVersion, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("planning failed: %w", err)
+	}
+
+	return plan, current.unmarked, nil
+}
+
+// simulate plans the steps from the state, limited to the given number of steps, if positive.
+// The state is updated as if the steps ran.
+func (m migrator[T]) simulate(current *state, direction Direction, targetVersion int64, steps int) ([]PlannedMigration, error) {
+	var plan []PlannedMigration
+
+	for steps <= 0 || len(plan) < steps {
+		next, err := m.nextStep(current, direction, targetVersion)
+		if err != nil {
+			return plan, err
+		}
+
+		if next == nil {
+			break
+		}
+
+		planned := next.planned()
+		plan = append(plan, planned)
+		current.apply(planned)
+	}
+
+	return plan, nil
+}
+
+// pending plans the steps left after a run stopped, from the state read before the failed step.
+// The failed step itself isn't pending. Steps that can't be planned are left out.
+func (m migrator[T]) pending(current *state, direction Direction, targetVersion int64, failed *step[T], steps int) []PlannedMigration {
+	plan, _ := m.simulate(current, direction, targetVersion, steps)
+	if failed != nil && len(plan) > 0 {
+		plan = plan[1:]
+	}
+
+	return plan
+}
+
+func (m migrator[T]) DryRun(ctx context.Context, direction Direction, targetVersion int64) ([]PlannedMigration, error) {
+	if err := checkDirection(direction); err != nil {
+		return nil, err
+	}
+
+	if len(m.migrations) == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	targetVersion = m.resolveTarget(targetVersion)
+
+	if m.config.validateChecksums {
+		if err := m.Validate(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	handle := func(currentVersion int64, tx T) (bool, int64, error) {
-		nextVersion, migration := m.findNextMigration(currentVersion)
-		if nextVersion == -1 {
-			if currentVersion < targetVersion {
-				return false, currentVersion, ErrMigrationNotFound
+	logger := m.config.logger.With("direction", direction, "target_version", targetVersion)
+	logger.InfoContext(ctx, "starting dry run")
+
+	var ran []PlannedMigration
+
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		return m.conn.Transaction(ctx, func(tx T) error {
+			for {
+				state, err := m.readState(ctx, tx, true)
+				if err != nil {
+					return err
+				}
+
+				if state.dirty {
+					return &DirtyError{Version: state.dirtyVersion}
+				}
+
+				next, err := m.nextStep(state, direction, targetVersion)
+				if err != nil {
+					return err
+				}
+
+				if next == nil {
+					return errDryRun
+				}
+
+				if isNonTransactional(next.migration) {
+					return fmt.Errorf("migration %d: %w", next.migration.Version(), ErrNoTransactionDryRun)
+				}
+
+				if err := m.runStep(ctx, tx, next); err != nil {
+					return err
+				}
+
+				logger.DebugContext(ctx, "dry run migration", "version", next.migration.Version())
+				ran = append(ran, next.planned())
 			}
-			return false, currentVersion, nil
+		})
+	})
+	if !errors.Is(err, errDryRun) {
+		logger.ErrorContext(ctx, "dry run failed", "error", err)
+		return ran, fmt.Errorf("dry run failed: %w", err)
+	}
+
+	logger.InfoContext(ctx, "dry run rolled back", "migrations", len(ran))
+	return ran, nil
+}
+
+func (m migrator[T]) Status(ctx context.Context) (*Status, error) {
+	var current *state
+
+	err := m.readTransaction(ctx, func(tx T) error {
+		var err error
+		current, err = m.readState(ctx, tx, false)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading status failed: %w", err)
+	}
+
+	status := &Status{
+		CurrentVersion: current.currentVersion,
+		Dirty:          current.dirty,
+		DirtyVersion:   current.dirtyVersion,
+	}
+
+	for _, migration := range m.migrations {
+		version := migration.Version()
+		if current.isApplied(version) {
+			status.Applied = append(status.Applied, version)
+		} else {
+			status.Pending = append(status.Pending, version)
+		}
+	}
+
+	for version := range current.applied {
+		if m.findMigration(version) == -1 {
+			status.Unknown = append(status.Unknown, version)
+		}
+	}
+
+	if current.currentVersion != 0 && m.findMigration(current.currentVersion) == -1 && !current.applied[current.currentVersion] {
+		status.Unknown = append(status.Unknown, current.currentVersion)
+	}
+
+	slices.Sort(status.Unknown)
+
+	return status, nil
+}
+
+func (m migrator[T]) History(ctx context.Context) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+
+	err := m.readTransaction(ctx, func(tx T) error {
+		historyVersioner, ok := any(tx).(HistoryVersioner)
+		if !ok {
+			return ErrHistoryNotSupported
 		}
-		if nextVersion > targetVersion {
-			return false, currentVersion, ErrMigrationNotFound
+
+		var err error
+		history, err = historyVersioner.GetHistory(ctx)
+		if err != nil {
+			return fmt.Errorf("getting history: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading history failed: %w", err)
+	}
+
+	return history, nil
+}
+
+func (m migrator[T]) Validate(ctx context.Context) error {
+	var checksums map[int64]string
+
+	err := m.readTransaction(ctx, func(tx T) error {
+		checksumVersioner, ok := any(tx).(ChecksumVersioner)
+		if !ok {
+			return ErrChecksumsNotSupported
 		}
 
-		err := migration.Up(ctx, tx)
+		var err error
+		checksums, err = checksumVersioner.GetChecksums(ctx)
 		if err != nil {
-			return false, currentVersion, fmt.Errorf("applying migration %d: %w", nextVersion, err)
+			return fmt.Errorf("getting checksums: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	var mismatches []int64
+
+	for _, migration := range m.migrations {
+		checksummer, ok := migration.(Checksummer)
+		if !ok {
+			continue
+		}
+
+		// Migrations applied before checksums were stored can't be validated.
+		stored, ok := checksums[migration.Version()]
+		if !ok {
+			continue
 		}
 
-		return nextVersion < targetVersion, nextVersion, nil
+		if stored != checksummer.Checksum() {
+			mismatches = append(mismatches, migration.Version())
+		}
 	}
 
-	if err := m.handler(ctx, targetVersion, handle); err != nil {
-		return fmt.Errorf("upgrade failed: %w", err)
+	if len(mismatches) > 0 {
+		return &ChecksumMismatchError{Versions: mismatches}
 	}
 
 	return nil
 }
 
-func (m migrator[T]) Down(ctx context.Context, targetVersion int64) error {
-	if len(m.migrations) == 0 {
-		return ErrNoMigrations
+func (m migrator[T]) Force(ctx context.Context, version int64) error {
+	if version != 0 && m.findMigration(version) == -1 {
+		return fmt.Errorf("forcing version %d: %w", version, ErrMigrationNotFound)
 	}
 
-	if targetVersion == Oldest {
-		targetVersion = m.migrations[0].Version()
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		return m.conn.Transaction(ctx, func(tx T) error {
+			return m.force(ctx, tx, version)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("forcing version %d failed: %w", version, err)
 	}
 
-	handle := func(currentVersion int64, tx T) (bool, int64, error) {
-		nextVersion, migration := m.findPrevMigration(currentVersion)
-		if nextVersion == -1 {
-			if currentVersion > targetVersion {
-				return false, currentVersion, ErrMigrationNotFound
+	m.config.logger.WarnContext(ctx, "version forced", "version", version)
+	return nil
+}
+
+// force stores the version, keeps the applied versions consistent with it, and clears the dirty flag.
+func (m migrator[T]) force(ctx context.Context, tx T, version int64) error {
+	if appliedVersioner, ok := any(tx).(AppliedVersioner); ok {
+		applied, err := appliedVersioner.GetAppliedVersions(ctx)
+		if err != nil {
+			return fmt.Errorf("getting applied versions: %w", err)
+		}
+
+		for _, appliedVersion := range applied {
+			if appliedVersion <= version {
+				continue
+			}
+			if err := appliedVersioner.MarkReverted(ctx, appliedVersion); err != nil {
+				return fmt.Errorf("marking version %d as reverted: %w", appliedVersion, err)
 			}
-			return false, currentVersion, nil
 		}
 
-		if nextVersion < targetVersion {
-			return false, currentVersion, ErrMigrationNotFound
+		if version != 0 && !slices.Contains(applied, version) {
+			if err := appliedVersioner.MarkApplied(ctx, version); err != nil {
+				return fmt.Errorf("marking version %d as applied: %w", version, err)
+			}
+		}
+	}
+
+	if err := tx.SetVersion(ctx, version); err != nil {
+		return fmt.Errorf("setting version: %w", err)
+	}
+
+	if dirtyVersioner, ok := any(tx).(DirtyVersioner); ok {
+		if err := dirtyVersioner.ClearDirtyVersion(ctx); err != nil {
+			return fmt.Errorf("clearing dirty version: %w", err)
 		}
+	}
+
+	return nil
+}
+
+// readState reads the current version and, if supported by the versioner, the applied versions.
+// Databases migrated before the applied versions were tracked only have a current version,
+// so every registered migration up to it is considered applied. When backfill is set, they are also stored.
+func (m migrator[T]) readState(ctx context.Context, tx T, backfill bool) (*state, error) {
+	currentVersion, err := tx.GetCurrentVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting current version: %w", err)
+	}
+
+	current := &state{
+		currentVersion: currentVersion,
+	}
 
-		if err := migration.Down(ctx, tx); err != nil {
-			return false, currentVersion, fmt.Errorf("applying migration %d: %w", nextVersion, err)
+	if dirtyVersioner, ok := any(tx).(DirtyVersioner); ok {
+		current.dirtyVersion, current.dirty, err = dirtyVersioner.GetDirtyVersion(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting dirty version: %w", err)
 		}
+	}
 
-		return nextVersion > targetVersion, nextVersion, nil
+	appliedVersioner, ok := any(tx).(AppliedVersioner)
+	if !ok {
+		if m.config.ordering != orderingDefault {
+			return nil, ErrAppliedVersionsNotSupported
+		}
+		return current, nil
 	}
 
-	if err := m.handler(ctx, targetVersion, handle); err != nil {
-		return fmt.Errorf("rollback failed: %w", err)
+	versions, err := appliedVersioner.GetAppliedVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting applied versions: %w", err)
+	}
+
+	current.applied = make(map[int64]bool, len(versions))
+	for _, version := range versions {
+		current.applied[version] = true
+	}
+
+	if len(versions) > 0 || currentVersion == 0 {
+		return current, nil
+	}
+
+	for _, migration := range m.migrations {
+		version := migration.Version()
+		if version > currentVersion {
+			break
+		}
+
+		current.applied[version] = true
+
+		if !backfill {
+			current.unmarked = append(current.unmarked, version)
+			continue
+		}
+
+		if err := appliedVersioner.MarkApplied(ctx, version); err != nil {
+			return nil, fmt.Errorf("marking version %d as applied: %w", version, err)
+		}
+	}
+
+	return current, nil
+}
+
+// nextStep returns the next migration to apply or revert, or nil if the target version is reached.
+// Irreversible migrations are refused, unless WithIrreversibleOverride is used.
+func (m migrator[T]) nextStep(current *state, direction Direction, targetVersion int64) (*step[T], error) {
+	next, err := m.findStep(current, direction, targetVersion)
+	if err != nil || next == nil {
+		return next, err
+	}
+
+	if next.direction == DirectionDown && !m.config.irreversibleOverride && isIrreversible(next.migration) {
+		return nil, &IrreversibleError{Version: next.migration.Version()}
+	}
+
+	return next, nil
+}
+
+func (m migrator[T]) findStep(current *state, direction Direction, targetVersion int64) (*step[T], error) {
+	switch {
+	case direction == DirectionUp && m.config.ordering == orderingOutOfOrder:
+		return m.nextUnappliedStep(current, targetVersion)
+	case direction == DirectionUp && m.config.ordering == orderingStrict:
+		if skipped := m.skippedVersions(current); len(skipped) > 0 {
+			return nil, &OutOfOrderError{Versions: skipped}
+		}
+		return m.nextUpStep(current, targetVersion)
+	case direction == DirectionUp:
+		return m.nextUpStep(current, targetVersion)
+	case direction == DirectionDown && m.config.ordering == orderingOutOfOrder:
+		return m.nextAppliedStep(current, targetVersion)
+	case direction == DirectionDown:
+		return m.nextDownStep(current, targetVersion)
+	default:
+		return nil, checkDirection(direction)
+	}
+}
+
+// nextUpStep returns the first migration newer than the current version.
+func (m migrator[T]) nextUpStep(current *state, targetVersion int64) (*step[T], error) {
+	if current.currentVersion >= targetVersion {
+		return nil, nil
+	}
+
+	nextVersion, migration := m.findNextMigration(current.currentVersion)
+	if nextVersion == -1 || nextVersion > targetVersion {
+		return nil, ErrMigrationNotFound
+	}
+
+	return &step[T]{
+		migration:   migration,
+		direction:   DirectionUp,
+		nextVersion: nextVersion,
+	}, nil
+}
+
+// nextDownStep returns the migration of the current version, which is reverted to the previous one.
+func (m migrator[T]) nextDownStep(current *state, targetVersion int64) (*step[T], error) {
+	if current.currentVersion <= targetVersion {
+		return nil, nil
+	}
+
+	index := m.findMigration(current.currentVersion)
+	if index == -1 {
+		return nil, fmt.Errorf("current version %d: %w", current.currentVersion, ErrMigrationNotFound)
+	}
+
+	prevVersion, _ := m.findPrevMigration(current.currentVersion)
+	if prevVersion == -1 {
+		prevVersion = 0
+	}
+
+	if prevVersion < targetVersion {
+		return nil, ErrMigrationNotFound
+	}
+
+	return &step[T]{
+		migration:   m.migrations[index],
+		direction:   DirectionDown,
+		nextVersion: prevVersion,
+	}, nil
+}
+
+// nextUnappliedStep returns the oldest migration that was never applied, up to the target version.
+func (m migrator[T]) nextUnappliedStep(current *state, targetVersion int64) (*step[T], error) {
+	if targetVersion > current.currentVersion && m.findMigration(targetVersion) == -1 {
+		return nil, ErrMigrationNotFound
+	}
+
+	for _, migration := range m.migrations {
+		version := migration.Version()
+		if version > targetVersion {
+			break
+		}
+
+		if current.applied[version] {
+			continue
+		}
+
+		return &step[T]{
+			migration:   migration,
+			direction:   DirectionUp,
+			nextVersion: max(current.currentVersion, version),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// nextAppliedStep returns the newest applied migration, above the target version.
+func (m migrator[T]) nextAppliedStep(current *state, targetVersion int64) (*step[T], error) {
+	applied := make([]int64, 0, len(current.applied))
+	for version := range current.applied {
+		applied = append(applied, version)
+	}
+	slices.Sort(applied)
+
+	if len(applied) == 0 || applied[len(applied)-1] <= targetVersion {
+		return nil, nil
 	}
 
+	if targetVersion != 0 && m.findMigration(targetVersion) == -1 {
+		return nil, ErrMigrationNotFound
+	}
+
+	version := applied[len(applied)-1]
+
+	index := m.findMigration(version)
+	if index == -1 {
+		return nil, fmt.Errorf("applied version %d: %w", version, ErrMigrationNotFound)
+	}
+
+	nextVersion := int64(0)
+	if len(applied) > 1 {
+		nextVersion = applied[len(applied)-2]
+	}
+
+	return &step[T]{
+		migration:   m.migrations[index],
+		direction:   DirectionDown,
+		nextVersion: nextVersion,
+	}, nil
+}
+
+// skippedVersions returns the registered versions older than the current version that were never applied.
+func (m migrator[T]) skippedVersions(current *state) []int64 {
+	var skipped []int64
+
+	for _, migration := range m.migrations {
+		version := migration.Version()
+		if version >= current.currentVersion {
+			break
+		}
+
+		if !current.applied[version] {
+			skipped = append(skipped, version)
+		}
+	}
+
+	return skipped
+}
+
+func (m migrator[T]) runStep(ctx context.Context, tx T, next *step[T]) error {
+	startedAt := time.Now()
+
+	if err := m.runMigration(ctx, tx, next); err != nil {
+		return next.fail(PhaseMigration, err)
+	}
+
+	if err := m.completeStep(ctx, tx, next, startedAt); err != nil {
+		return next.fail(PhaseVersionWrite, err)
+	}
 	return nil
 }
 
+// runNonTransactional runs a NonTransactional migration outside a transaction,
+// then completes the step and clears the dirty flag in a new transaction.
+// If the migration fails, the dirty flag set by setDirty stays.
+func (m migrator[T]) runNonTransactional(ctx context.Context, next *step[T], startedAt time.Time) error {
+	db, ok := any(m.conn).(NonTransactionalDatabase[T])
+	if !ok {
+		return ErrNoTransactionNotSupported
+	}
+
+	m.config.logger.DebugContext(ctx, "running migration outside a transaction", "version", next.migration.Version())
+
+	var migrationErr *MigrationError
+
+	err := db.WithoutTransaction(ctx, func(tx T) error {
+		if err := m.runMigration(ctx, tx, next); err != nil {
+			return next.fail(PhaseMigration, err)
+		}
+		return nil
+	})
+	if err != nil {
+		// Errors other than the migration's are from opening the connection.
+		if !errors.As(err, &migrationErr) {
+			err = next.fail(PhaseBegin, err)
+		}
+		return err
+	}
+
+	return m.transaction(ctx, next.fail, func(tx T) error {
+		if err := m.completeStep(ctx, tx, next, startedAt); err != nil {
+			return next.fail(PhaseVersionWrite, err)
+		}
+
+		if err := any(tx).(DirtyVersioner).ClearDirtyVersion(ctx); err != nil {
+			return next.fail(PhaseVersionWrite, fmt.Errorf("clearing dirty version: %w", err))
+		}
+		return nil
+	})
+}
+
+// runMigration applies or reverts the migration of the step.
+func (m migrator[T]) runMigration(ctx context.Context, tx T, next *step[T]) error {
+	version := next.migration.Version()
+
+	if next.direction == DirectionUp {
+		if err := next.migration.Up(ctx, tx); err != nil {
+			return fmt.Errorf("applying migration %d: %w", version, err)
+		}
+		return nil
+	}
+
+	if isIrreversible(next.migration) {
+		// Only reachable with WithIrreversibleOverride.
+		m.config.logger.WarnContext(ctx, "skipping irreversible migration", "version", version)
+		next.skipped = true
+		return nil
+	}
+
+	err := next.migration.Down(ctx, tx)
+	switch {
+	case errors.Is(err, ErrIrreversible) && m.config.irreversibleOverride:
+		m.config.logger.WarnContext(ctx, "skipping irreversible migration", "version", version)
+		next.skipped = true
+		return nil
+	case errors.Is(err, ErrIrreversible):
+		return &IrreversibleError{Version: version}
+	case err != nil:
+		return fmt.Errorf("reverting migration %d: %w", version, err)
+	}
+	return nil
+}
+
+// completeStep stores the outcome of the step: applied versions, checksum, history and the new version.
+func (m migrator[T]) completeStep(ctx context.Context, tx T, next *step[T], startedAt time.Time) error {
+	version := next.migration.Version()
+
+	if err := m.markApplied(ctx, tx, version, next.direction); err != nil {
+		return err
+	}
+
+	if next.direction == DirectionUp {
+		if err := m.storeChecksum(ctx, tx, next.migration); err != nil {
+			return err
+		}
+	}
+
+	if err := m.recordHistory(ctx, tx, version, next.direction, startedAt); err != nil {
+		return err
+	}
+
+	if err := tx.SetVersion(ctx, next.nextVersion); err != nil {
+		return fmt.Errorf("setting new version: %w", err)
+	}
+
+	return nil
+}
+
+// setDirty flags the version of a NonTransactional migration as dirty, before it runs.
+func (m migrator[T]) setDirty(ctx context.Context, tx T, next *step[T]) error {
+	if _, ok := any(m.conn).(NonTransactionalDatabase[T]); !ok {
+		return fmt.Errorf("migration %d: %w", next.migration.Version(), ErrNoTransactionNotSupported)
+	}
+
+	dirtyVersioner, ok := any(tx).(DirtyVersioner)
+	if !ok {
+		return fmt.Errorf("migration %d: %w", next.migration.Version(), ErrDirtyNotSupported)
+	}
+
+	if err := dirtyVersioner.SetDirtyVersion(ctx, next.migration.Version()); err != nil {
+		return next.fail(PhaseVersionWrite, fmt.Errorf("setting dirty version: %w", err))
+	}
+	return nil
+}
+
+// isIrreversible tells if the migration can't be reverted.
+func isIrreversible(migration any) bool {
+	irreversible, ok := migration.(Irreversible)
+	return ok && irreversible.Irreversible()
+}
+
+// isNonTransactional tells if the migration must run outside a transaction.
+func isNonTransactional(migration any) bool {
+	nonTransactional, ok := migration.(NonTransactional)
+	return ok && nonTransactional.NoTransaction()
+}
+
+func (m migrator[T]) beforeMigration(ctx context.Context, next *step[T]) error {
+	event := Event{
+		Version:   next.migration.Version(),
+		Direction: next.direction,
+	}
+
+	m.config.logger.DebugContext(ctx, "running migration", "version", event.Version, "direction", event.Direction)
+
+	for _, observer := range m.config.observers {
+		if err := observer.BeforeMigration(ctx, event); err != nil {
+			return fmt.Errorf("%w: version %d: %w", ErrVetoed, event.Version, err)
+		}
+	}
+	return nil
+}
+
+func (m migrator[T]) afterMigration(ctx context.Context, next *step[T], startedAt time.Time) {
+	event := Event{
+		Version:   next.migration.Version(),
+		Direction: next.direction,
+		Duration:  time.Since(startedAt),
+	}
+
+	message := "migration applied"
+	if event.Direction == DirectionDown {
+		message = "migration reverted"
+	}
+	m.config.logger.InfoContext(ctx, message, "version", event.Version, "direction", event.Direction, "duration", event.Duration)
+
+	for _, observer := range m.config.observers {
+		observer.AfterMigration(ctx, event)
+	}
+}
+
+func (m migrator[T]) onError(ctx context.Context, next *step[T], startedAt time.Time, err error) {
+	event := Event{
+		Version:   next.migration.Version(),
+		Direction: next.direction,
+		Duration:  time.Since(startedAt),
+		Err:       err,
+	}
+
+	m.config.logger.ErrorContext(ctx, "migration failed",
+		"version", event.Version,
+		"direction", event.Direction,
+		"duration", event.Duration,
+		"error", err,
+	)
+
+	for _, observer := range m.config.observers {
+		observer.OnError(ctx, event)
+	}
+}
+
+// markUnmarked stores the versions applied before they were tracked, as readState does with backfill.
+func (m migrator[T]) markUnmarked(ctx context.Context, tx T, versions []int64) error {
+	for _, version := range versions {
+		if err := m.markApplied(ctx, tx, version, DirectionUp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m migrator[T]) markApplied(ctx context.Context, tx T, version int64, direction Direction) error {
+	appliedVersioner, ok := any(tx).(AppliedVersioner)
+	if !ok {
+		return nil
+	}
+
+	if direction == DirectionUp {
+		if err := appliedVersioner.MarkApplied(ctx, version); err != nil {
+			return fmt.Errorf("marking version %d as applied: %w", version, err)
+		}
+		return nil
+	}
+
+	if err := appliedVersioner.MarkReverted(ctx, version); err != nil {
+		return fmt.Errorf("marking version %d as reverted: %w", version, err)
+	}
+	return nil
+}
+
+func (m migrator[T]) storeChecksum(ctx context.Context, tx T, migration Migration[T]) error {
+	checksumVersioner, ok := any(tx).(ChecksumVersioner)
+	if !ok {
+		return nil
+	}
+
+	checksummer, ok := migration.(Checksummer)
+	if !ok {
+		return nil
+	}
+
+	if err := checksumVersioner.SetChecksum(ctx, migration.Version(), checksummer.Checksum()); err != nil {
+		return fmt.Errorf("storing checksum of migration %d: %w", migration.Version(), err)
+	}
+	return nil
+}
+
+func (m migrator[T]) recordHistory(ctx context.Context, tx T, version int64, direction Direction, startedAt time.Time) error {
+	historyVersioner, ok := any(tx).(HistoryVersioner)
+	if !ok {
+		return nil
+	}
+
+	entry := HistoryEntry{
+		Version:   version,
+		Direction: direction,
+		AppliedAt: startedAt,
+		Duration:  time.Since(startedAt),
+	}
+
+	if err := historyVersioner.RecordHistory(ctx, entry); err != nil {
+		return fmt.Errorf("recording history of migration %d: %w", version, err)
+	}
+	return nil
+}
+
+// apply updates the state as if the planned migration ran. It's used to plan the following steps.
+func (s *state) apply(planned PlannedMigration) {
+	s.currentVersion = planned.NextVersion
+
+	if s.applied == nil {
+		return
+	}
+
+	if planned.Direction == DirectionUp {
+		s.applied[planned.Version] = true
+	} else {
+		delete(s.applied, planned.Version)
+	}
+}
+
+// planned describes the step, as returned by Plan.
+func (s step[T]) planned() PlannedMigration {
+	return PlannedMigration{
+		Version:     s.migration.Version(),
+		Direction:   s.direction,
+		NextVersion: s.nextVersion,
+	}
+}
+
+// fail wraps the error of the step in a MigrationError.
+func (s step[T]) fail(phase Phase, err error) error {
+	return newMigrationError(s.migration.Version(), s.direction, phase, err)
+}
+
+// isApplied tells if the version is applied, using the applied versions when they are tracked.
+func (s *state) isApplied(version int64) bool {
+	if s.applied != nil {
+		return s.applied[version]
+	}
+	return version <= s.currentVersion
+}
+
 func validateMigrations[T Versioner](migrations ...Migration[T]) error {
 	if len(migrations) == 0 {
 		return ErrNoMigrations
@@ -147,6 +1250,16 @@ func validateMigrations[T Versioner](migrations ...Migration[T]) error {
 	return nil
 }
 
+// findMigration returns the index of the migration with the given version, or -1 if it's not registered.
+func (m migrator[T]) findMigration(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version() == version {
+			return i
+		}
+	}
+	return -1
+}
+
 func (m migrator[T]) findNextMigration(currentVersion int64) (int64, Migration[T]) {
 	for _, migration := range m.migrations {
 		if migration.Version() > currentVersion {
@@ -3,7 +3,12 @@ package migrate
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type (
@@ -15,12 +20,129 @@ type (
 		SetVersion(ctx context.Context, version int64) error
 	}
 
+	// HistoryVersioner is an optional extension of Versioner that keeps a record of every migration execution.
+	// When the versioner implements it, the migrator records each applied or reverted migration.
+	HistoryVersioner interface {
+		Versioner
+		// RecordHistory stores a new entry in the migration history.
+		RecordHistory(ctx context.Context, entry HistoryEntry) error
+		// GetHistory returns all entries of the migration history, from the oldest to the newest.
+		GetHistory(ctx context.Context) ([]HistoryEntry, error)
+	}
+
+	// AppliedVersioner is an optional extension of Versioner that tracks every applied version individually,
+	// instead of a single high-water mark.
+	// When the versioner implements it, the migrator keeps the applied versions up to date.
+	// It's required by WithOutOfOrder and WithStrictOrder.
+	AppliedVersioner interface {
+		Versioner
+		// GetAppliedVersions returns all applied versions, in any order.
+		GetAppliedVersions(ctx context.Context) ([]int64, error)
+		// MarkApplied adds the version to the applied versions.
+		MarkApplied(ctx context.Context, version int64) error
+		// MarkReverted removes the version from the applied versions.
+		MarkReverted(ctx context.Context, version int64) error
+	}
+
+	// ChecksumVersioner is an optional extension of Versioner that stores the checksum of each applied migration.
+	// When the versioner implements it, the migrator stores the checksum of every applied migration implementing Checksummer.
+	ChecksumVersioner interface {
+		Versioner
+		// GetChecksums returns the stored checksums, indexed by version.
+		GetChecksums(ctx context.Context) (map[int64]string, error)
+		// SetChecksum stores the checksum of an applied version.
+		SetChecksum(ctx context.Context, version int64, checksum string) error
+	}
+
+	// DirtyVersioner is an optional extension of Versioner that flags a version as partially applied.
+	// It's required by NonTransactional migrations: their version is flagged before they run, and cleared after.
+	// If the migration fails, the flag stays, so the database is explicitly marked as inconsistent.
+	// While it's set, Up and Down return a DirtyError, until the flag is cleared by Migrator.Force.
+	DirtyVersioner interface {
+		Versioner
+		// GetDirtyVersion returns the version flagged as dirty, if any.
+		GetDirtyVersion(ctx context.Context) (version int64, dirty bool, err error)
+		// SetDirtyVersion flags the version as dirty.
+		SetDirtyVersion(ctx context.Context, version int64) error
+		// ClearDirtyVersion removes the dirty flag.
+		ClearDirtyVersion(ctx context.Context) error
+	}
+
+	// Direction describes whether a migration is being applied or reverted.
+	Direction string
+
+	// HistoryEntry describes a single execution of a migration.
+	HistoryEntry struct {
+		// Version is the version of the executed migration.
+		Version int64
+		// Direction tells if the migration was applied or reverted.
+		Direction Direction
+		// AppliedAt is when the migration started running.
+		AppliedAt time.Time
+		// Duration is how long the migration took to run.
+		Duration time.Duration
+		// Host is the name of the machine that executed the migration. It's filled by the versioner.
+		Host string
+		// App is the name of the application that executed the migration. It's filled by the versioner.
+		App string
+	}
+
 	// Database abstracts a database wrapper that can be used to perform transactions.
 	// It's implemented by a database. Example: github.com/sonalys/codemigrate/databases/postgres/pgx/adapter.
 	Database[V Versioner] interface {
 		Transaction(ctx context.Context, handler func(tx V) error) error
 	}
 
+	// NonTransactionalDatabase is an optional extension of Database that runs statements outside a transaction.
+	// It's required by NonTransactional migrations.
+	NonTransactionalDatabase[V Versioner] interface {
+		Database[V]
+		// WithoutTransaction calls the handler with a versioner whose statements are committed as they run.
+		WithoutTransaction(ctx context.Context, handler func(tx V) error) error
+	}
+
+	// RecordingDatabase is an optional extension of Database that records statements instead of running them.
+	// It's required by Migrator.Render.
+	RecordingDatabase[V Versioner] interface {
+		Database[V]
+		// SetupStatements returns the statements that prepare the database for the versioner, such as creating its tables.
+		SetupStatements() []string
+		// Record calls the handler with a versioner that records every statement instead of running it,
+		// and returns them in order, with their arguments inlined.
+		// Reading from the database through the versioner fails with ErrNotRenderable.
+		Record(ctx context.Context, handler func(tx V) error) ([]string, error)
+	}
+
+	// ReadOnlyDatabase is an optional extension of Database that reads the migration state
+	// without waiting for the migration lock.
+	// When it's implemented, Status, History, Validate and Plan use it instead of Transaction,
+	// so they don't wait for another process to finish migrating.
+	ReadOnlyDatabase[V Versioner] interface {
+		Database[V]
+		// ReadTransaction calls the handler with a versioner that only reads from the database.
+		ReadTransaction(ctx context.Context, handler func(tx V) error) error
+	}
+
+	// Locker prevents concurrent migrations of the same database.
+	// It's an optional interface for a Database, and can be replaced by WithLocker.
+	// Up and Down acquire it once, for the whole process, instead of once per transaction.
+	Locker interface {
+		// Lock blocks until the lock is acquired, or the context is canceled.
+		Lock(ctx context.Context) error
+		// Unlock releases the lock acquired by Lock.
+		Unlock(ctx context.Context) error
+	}
+
+	// ExpiringLocker is an optional extension of Locker for locks that can be lost while they're held, such as LeaseLocker.
+	// When it's implemented, the migrations run with the context returned by LockContext,
+	// so losing the lock cancels them, instead of letting another process migrate concurrently.
+	ExpiringLocker interface {
+		Locker
+		// LockContext acquires the lock like Lock, and returns a context derived from ctx,
+		// that is canceled if the lock is lost before Unlock is called.
+		LockContext(ctx context.Context) (context.Context, error)
+	}
+
 	// Migration abstracts each migration that can be applied to the database.
 	// You can implement this interface to create your own migrations.
 	Migration[V Versioner] interface {
@@ -34,31 +156,157 @@ type (
 		Version() int64
 	}
 
+	// NonTransactional is an optional interface for migrations that can't run inside a transaction,
+	// such as CREATE INDEX CONCURRENTLY or VACUUM.
+	// When NoTransaction returns true, the migration runs through NonTransactionalDatabase.WithoutTransaction,
+	// and its version is flagged as dirty by the DirtyVersioner until it completes.
+	NonTransactional interface {
+		NoTransaction() bool
+	}
+
+	// Irreversible is an optional interface for migrations that can't be reverted, such as dropping data.
+	// When Irreversible returns true, Down stops before the migration with an IrreversibleError,
+	// unless WithIrreversibleOverride is used.
+	Irreversible interface {
+		Irreversible() bool
+	}
+
+	// Checksummer is an optional interface for migrations whose content can change after being applied,
+	// such as SQL scripts. It's used to detect when an applied migration was edited.
+	Checksummer interface {
+		// Checksum returns a digest of the migration content.
+		Checksum() string
+	}
+
 	// Migrator abstracts the migration process.
 	// It can be used to apply or revert migrations.
 	// It's initialized by the New function.
 	Migrator interface {
 		// Up applies the migrations to the database.
 		// If it's running by the first time, it will apply all migrations.
-		// If no migrations were applied, it will return ErrNoMigrations.
-		// TargetVersion should be greater than the current version, or it will return ErrNoMigrations.
+		// If there are no registered migrations, it will return ErrNoMigrations.
+		// If the database is already at or above the target version, it does nothing and returns nil.
 		// There must be a migration for the target version, or it will return ErrMigrationNotFound.
+		// Migrations older than the current version are skipped, unless WithOutOfOrder or WithStrictOrder is used.
 		// You can use migrate.Latest to apply all migrations.
+		// If a migration was left partially applied, it will return a DirtyError.
 		Up(ctx context.Context, targetVersion int64) error
 		// Down reverts the migrations to the database.
-		// If no migrations were applied, it will return ErrNoMigrations.
-		// TargetVersion should be less than the current version, or it will return ErrNoMigrations.
+		// If there are no registered migrations, it will return ErrNoMigrations.
+		// If the database is already at or below the target version, it does nothing and returns nil.
 		// There must be a migration for the target version, or it will return ErrMigrationNotFound.
-		// You can use migrate.Oldest to revert all migrations.
+		// You can use migrate.Oldest to revert all migrations but the first,
+		// or migrate.Zero to revert all of them, leaving the database at version 0.
+		// If a migration was left partially applied, it will return a DirtyError.
+		// If a migration can't be reverted, it stops before it with an IrreversibleError.
 		Down(ctx context.Context, targetVersion int64) error
+		// Run applies or reverts the migrations just like Up or Down, depending on the direction,
+		// and returns a Report of the migrations that ran. The report is returned even when an error stops the run.
+		// Directions other than DirectionUp and DirectionDown are refused with ErrInvalidDirection, like in Plan, DryRun and Render.
+		Run(ctx context.Context, direction Direction, targetVersion int64) (*Report, error)
+		// Steps applies the next n migrations, when n is positive, or reverts the last -n migrations, when n is negative.
+		// It stops early, without an error, when there are no more migrations to apply or revert.
+		Steps(ctx context.Context, n int) error
+		// Goto applies or reverts migrations until the database is at the given version,
+		// choosing the direction from the current version, read under the same lock as the run.
+		// It returns a Report like Run, without migrations if the database is already at the version.
+		// The version must be registered, or Zero, or it will return ErrMigrationNotFound.
+		Goto(ctx context.Context, version int64) (*Report, error)
+		// Redo reverts the migration of the current version, then applies it again, holding the lock for both steps.
+		// With WithOutOfOrder, it redoes the newest applied migration, without applying any other pending one.
+		// If no migrations were applied, it will return ErrNoMigrations.
+		Redo(ctx context.Context) error
+		// Plan returns the migrations that Up or Down would apply or revert, in order, without running them.
+		// The direction and target version are the same as Up and Down, including Latest and Oldest.
+		// It returns the same errors Up or Down would return before running a migration, such as a DirtyError,
+		// or an IrreversibleError when the plan reaches an irreversible migration.
+		Plan(ctx context.Context, direction Direction, targetVersion int64) ([]PlannedMigration, error)
+		// DryRun applies or reverts the migrations just like Up or Down, but in a single transaction that is rolled back.
+		// It's used to validate migrations against a real database, without changing it.
+		// It returns the migrations that ran, and observers aren't notified.
+		// Migrations that can't run inside a transaction fail with ErrNoTransactionDryRun.
+		DryRun(ctx context.Context, direction Direction, targetVersion int64) ([]PlannedMigration, error)
+		// Render returns the script that Up or Down would run, for the current state of the database, without running it.
+		// Each migration is recorded along with the statements storing its version.
+		// Migrations reading from the database can't be rendered, and are reported in a NotRenderableError.
+		// If the database doesn't implement RecordingDatabase, it will return ErrRenderNotSupported.
+		Render(ctx context.Context, direction Direction, targetVersion int64) (*Script, error)
+		// Status reports the current version of the database, alongside the applied and pending migrations.
+		// It doesn't apply or revert any migration.
+		Status(ctx context.Context) (*Status, error)
+		// History returns every recorded execution of a migration, from the oldest to the newest.
+		// If the versioner doesn't implement HistoryVersioner, it will return ErrHistoryNotSupported.
+		History(ctx context.Context) ([]HistoryEntry, error)
+		// Validate compares the checksum of every applied migration with the one stored when it was applied.
+		// If an applied migration was edited since, it will return a ChecksumMismatchError.
+		// If the versioner doesn't implement ChecksumVersioner, it will return ErrChecksumsNotSupported.
+		Validate(ctx context.Context) error
+		// Force stores the given version and clears the dirty flag, without running any migration.
+		// It's used to repair the database by hand, after Up or Down returned a DirtyError.
+		// When the versioner implements AppliedVersioner, the version is marked as applied,
+		// and newer applied versions are marked as reverted.
+		// The version must be registered, or 0, or it will return ErrMigrationNotFound.
+		Force(ctx context.Context, version int64) error
+	}
+
+	// Config holds the migrator configuration. It's changed through Options.
+	Config struct {
+		ordering             ordering
+		validateChecksums    bool
+		irreversibleOverride bool
+		observers            []Observer
+		logger               *slog.Logger
+		tracer               trace.Tracer
+		locker               Locker
 	}
+
+	// PlannedMigration describes a migration to be applied or reverted.
+	// It's returned by Migrator.Plan and Migrator.DryRun.
+	PlannedMigration struct {
+		// Version is the version of the migration.
+		Version int64
+		// Direction tells if the migration is applied or reverted.
+		Direction Direction
+		// NextVersion is the version stored after the migration runs.
+		NextVersion int64
+	}
+
+	// Option customizes the migrator created by NewWithOptions.
+	Option func(*Config)
+
+	// Status describes the migration state of the database.
+	// It's returned by Migrator.Status.
+	Status struct {
+		// CurrentVersion is the version stored in the database. It's 0 if no migrations were applied.
+		CurrentVersion int64
+		// Applied lists the versions of the registered migrations that are applied, in ascending order.
+		Applied []int64
+		// Pending lists the versions of the registered migrations that are not applied yet, in ascending order.
+		Pending []int64
+		// Unknown lists the versions stored in the database that match no registered migration.
+		Unknown []int64
+		// Dirty tells if a migration was left partially applied. It requires a DirtyVersioner.
+		Dirty bool
+		// DirtyVersion is the version of the partially applied migration, when Dirty is set.
+		DirtyVersion int64
+	}
+)
+
+const (
+	// DirectionUp is used when a migration is applied.
+	DirectionUp Direction = "up"
+	// DirectionDown is used when a migration is reverted.
+	DirectionDown Direction = "down"
 )
 
 const (
 	// Latest is a special value that can be used to apply all migrations.
 	Latest int64 = -1
-	// Oldest is a special value that can be used to revert all migrations.
+	// Oldest is a special value that can be used to revert all migrations, down to the first one.
+	// The first migration stays applied. Use Zero to revert it too.
 	Oldest int64 = -2
+	// Zero is the version of an empty schema. Down reverts every migration to reach it, including the first one.
+	Zero int64 = 0
 )
 
 // New creates a new migrator.
@@ -67,6 +315,11 @@ const (
 // It sorts the migrations by version and validates them.
 // At least one migration must be provided.
 func New[T Versioner](conn Database[T], migrations ...Migration[T]) (Migrator, error) {
+	return NewWithOptions(conn, migrations)
+}
+
+// NewWithOptions creates a new migrator, just like New, customized by the given options.
+func NewWithOptions[T Versioner](conn Database[T], migrations []Migration[T], opts ...Option) (Migrator, error) {
 	sort.Slice(migrations, func(i, j int) bool {
 		return migrations[i].Version() < migrations[j].Version()
 	})
@@ -75,8 +328,18 @@ func New[T Versioner](conn Database[T], migrations ...Migration[T]) (Migrator, e
 		return nil, fmt.Errorf("validating migrations: %w", err)
 	}
 
-	return &migrator[T]{
+	m := &migrator[T]{
 		conn:       conn,
 		migrations: migrations,
-	}, nil
+		config: Config{
+			logger: slog.New(slog.DiscardHandler),
+			tracer: noop.NewTracerProvider().Tracer(tracerName),
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&m.config)
+	}
+
+	return m, nil
 }
@@ -1,17 +1,27 @@
 package migrate_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"testing"
+	"time"
 
 	"github.com/sonalys/codemigrate/migrate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type customTransaction struct {
 	getCurrentVersion func(ctx context.Context) (int64, error)
 	setVersion        func(ctx context.Context, version int64) error
+	recordHistory     func(ctx context.Context, entry migrate.HistoryEntry) error
+	getHistory        func(ctx context.Context) ([]migrate.HistoryEntry, error)
 }
 
 type customConnection[T migrate.Versioner] struct {
@@ -42,6 +52,234 @@ func (c customTransaction) SetVersion(ctx context.Context, version int64) error
 	return c.setVersion(ctx, version)
 }
 
+func (c customTransaction) RecordHistory(ctx context.Context, entry migrate.HistoryEntry) error {
+	if c.recordHistory == nil {
+		return nil
+	}
+	return c.recordHistory(ctx, entry)
+}
+
+func (c customTransaction) GetHistory(ctx context.Context) ([]migrate.HistoryEntry, error) {
+	if c.getHistory == nil {
+		return nil, nil
+	}
+	return c.getHistory(ctx)
+}
+
+type appliedTransaction struct {
+	customTransaction
+	applied map[int64]bool
+}
+
+func (c appliedTransaction) GetAppliedVersions(ctx context.Context) ([]int64, error) {
+	versions := make([]int64, 0, len(c.applied))
+	for version := range c.applied {
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (c appliedTransaction) MarkApplied(ctx context.Context, version int64) error {
+	c.applied[version] = true
+	return nil
+}
+
+func (c appliedTransaction) MarkReverted(ctx context.Context, version int64) error {
+	delete(c.applied, version)
+	return nil
+}
+
+type appliedMigration struct {
+	version int64
+}
+
+func (m appliedMigration) Up(ctx context.Context, tx appliedTransaction) error {
+	return nil
+}
+
+func (m appliedMigration) Down(ctx context.Context, tx appliedTransaction) error {
+	return nil
+}
+
+func (m appliedMigration) Version() int64 {
+	return m.version
+}
+
+type checksumTransaction struct {
+	customTransaction
+	checksums map[int64]string
+}
+
+func (c checksumTransaction) GetChecksums(ctx context.Context) (map[int64]string, error) {
+	return c.checksums, nil
+}
+
+func (c checksumTransaction) SetChecksum(ctx context.Context, version int64, checksum string) error {
+	c.checksums[version] = checksum
+	return nil
+}
+
+type scriptMigration struct {
+	version int64
+	script  string
+}
+
+func (m scriptMigration) Up(ctx context.Context, tx checksumTransaction) error {
+	return nil
+}
+
+func (m scriptMigration) Down(ctx context.Context, tx checksumTransaction) error {
+	return nil
+}
+
+func (m scriptMigration) Version() int64 {
+	return m.version
+}
+
+func (m scriptMigration) Checksum() string {
+	return m.script
+}
+
+type nonTransactionalConnection[T migrate.Versioner] struct {
+	customConnection[T]
+	withoutTransaction func(ctx context.Context, handler func(tx T) error) error
+}
+
+func (c nonTransactionalConnection[T]) WithoutTransaction(ctx context.Context, handler func(tx T) error) error {
+	return c.withoutTransaction(ctx, handler)
+}
+
+type dirtyState struct {
+	version int64
+	dirty   bool
+}
+
+type dirtyTransaction struct {
+	customTransaction
+	state         *dirtyState
+	inTransaction bool
+}
+
+func (c dirtyTransaction) GetDirtyVersion(ctx context.Context) (int64, bool, error) {
+	return c.state.version, c.state.dirty, nil
+}
+
+func (c dirtyTransaction) SetDirtyVersion(ctx context.Context, version int64) error {
+	c.state.version, c.state.dirty = version, true
+	return nil
+}
+
+func (c dirtyTransaction) ClearDirtyVersion(ctx context.Context) error {
+	c.state.version, c.state.dirty = 0, false
+	return nil
+}
+
+type dirtyMigration struct {
+	version       int64
+	noTransaction bool
+	up            func(tx dirtyTransaction) error
+}
+
+func (m dirtyMigration) Up(ctx context.Context, tx dirtyTransaction) error {
+	if m.up == nil {
+		return nil
+	}
+	return m.up(tx)
+}
+
+func (m dirtyMigration) Down(ctx context.Context, tx dirtyTransaction) error {
+	return nil
+}
+
+func (m dirtyMigration) Version() int64 {
+	return m.version
+}
+
+func (m dirtyMigration) NoTransaction() bool {
+	return m.noTransaction
+}
+
+type noTransactionMigration struct {
+	customMigration
+}
+
+func (m noTransactionMigration) NoTransaction() bool {
+	return true
+}
+
+// irreversibleMigration can't be reverted. When declared, it implements migrate.Irreversible,
+// otherwise its Down returns migrate.ErrIrreversible.
+type irreversibleMigration struct {
+	version  int64
+	declared bool
+	downRan  *bool
+}
+
+func (m irreversibleMigration) Up(ctx context.Context, tx customTransaction) error {
+	return nil
+}
+
+func (m irreversibleMigration) Down(ctx context.Context, tx customTransaction) error {
+	*m.downRan = true
+	return migrate.ErrIrreversible
+}
+
+func (m irreversibleMigration) Version() int64 {
+	return m.version
+}
+
+func (m irreversibleMigration) Irreversible() bool {
+	return m.declared
+}
+
+// failingMigration returns its error from Up.
+type failingMigration struct {
+	version int64
+	err     error
+}
+
+func (m failingMigration) Up(ctx context.Context, tx customTransaction) error {
+	return m.err
+}
+
+func (m failingMigration) Down(ctx context.Context, tx customTransaction) error {
+	return nil
+}
+
+func (m failingMigration) Version() int64 {
+	return m.version
+}
+
+type blockingMigration struct {
+	version int64
+	up      func(ctx context.Context) error
+}
+
+func (m blockingMigration) Up(ctx context.Context, tx customTransaction) error {
+	return m.up(ctx)
+}
+
+func (m blockingMigration) Down(ctx context.Context, tx customTransaction) error {
+	return nil
+}
+
+func (m blockingMigration) Version() int64 {
+	return m.version
+}
+
+// sqlStateError is a database error carrying a SQLSTATE code.
+type sqlStateError struct {
+	code string
+}
+
+func (e sqlStateError) Error() string {
+	return "database error " + e.code
+}
+
+func (e sqlStateError) SQLState() string {
+	return e.code
+}
+
 func (c customConnection[T]) Transaction(ctx context.Context, handler func(tx T) error) error {
 	return c.transaction(ctx, handler)
 }
@@ -263,6 +501,72 @@ func Test_Migrator_Down(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("success: reverts the migration of the current version", func(t *testing.T) {
+		ctx := t.Context()
+
+		var reverted []int64
+
+		migrations := []migrate.Migration[customTransaction]{
+			customMigration{version: 1},
+			customMigration{version: 2},
+			customMigration{version: 3},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithHooks(migrate.Hooks{
+			AfterMigration: func(ctx context.Context, event migrate.Event) {
+				reverted = append(reverted, event.Version)
+			},
+		}))
+		require.NoError(t, err)
+
+		currentVersion := int64(3)
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		}
+
+		err = migrator.Down(ctx, migrate.Oldest)
+		require.NoError(t, err)
+		require.Equal(t, []int64{3, 2}, reverted)
+		require.EqualValues(t, 1, currentVersion)
+	})
+
+	t.Run("success: migrates down to zero", func(t *testing.T) {
+		ctx := t.Context()
+
+		var reverted []int64
+
+		migrations := []migrate.Migration[customTransaction]{
+			customMigration{version: 1},
+			customMigration{version: 2},
+			customMigration{version: 3},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithHooks(migrate.Hooks{
+			AfterMigration: func(ctx context.Context, event migrate.Event) {
+				reverted = append(reverted, event.Version)
+			},
+		}))
+		require.NoError(t, err)
+
+		currentVersion := int64(3)
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		}
+
+		err = migrator.Down(ctx, migrate.Zero)
+		require.NoError(t, err)
+		require.Equal(t, []int64{3, 2, 1}, reverted)
+		require.Equal(t, migrate.Zero, currentVersion)
+	})
+
 	t.Run("error: migration to target version not found", func(t *testing.T) {
 		ctx := t.Context()
 		migrator, err := migrate.New(conn,
@@ -296,3 +600,1668 @@ func Test_Migrator_Down(t *testing.T) {
 		require.ErrorIs(t, err, migrate.ErrMigrationNotFound)
 	})
 }
+
+func Test_Migrator_Status(t *testing.T) {
+	transaction := customTransaction{}
+
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(transaction)
+		},
+	}
+
+	t.Run("success: empty database", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+			customMigration{version: 2},
+		)
+		require.NoError(t, err)
+
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return 0, nil
+		}
+
+		status, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, status.CurrentVersion)
+		require.Empty(t, status.Applied)
+		require.Equal(t, []int64{1, 2}, status.Pending)
+		require.Empty(t, status.Unknown)
+	})
+
+	t.Run("success: partially applied", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			customMigration{version: 3},
+			customMigration{version: 1},
+			customMigration{version: 2},
+		)
+		require.NoError(t, err)
+
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return 2, nil
+		}
+
+		status, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, status.CurrentVersion)
+		require.Equal(t, []int64{1, 2}, status.Applied)
+		require.Equal(t, []int64{3}, status.Pending)
+		require.Empty(t, status.Unknown)
+	})
+
+	t.Run("success: unknown database version", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+			customMigration{version: 3},
+		)
+		require.NoError(t, err)
+
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return 2, nil
+		}
+
+		status, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1}, status.Applied)
+		require.Equal(t, []int64{3}, status.Pending)
+		require.Equal(t, []int64{2}, status.Unknown)
+	})
+
+	t.Run("error: reading current version", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+		)
+		require.NoError(t, err)
+
+		expectedErr := errors.New("connection refused")
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return 0, expectedErr
+		}
+
+		status, err := migrator.Status(ctx)
+		require.ErrorIs(t, err, expectedErr)
+		require.Nil(t, status)
+	})
+}
+
+func Test_Migrator_History(t *testing.T) {
+	transaction := customTransaction{}
+
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(transaction)
+		},
+	}
+
+	t.Run("success: records applied and reverted migrations", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+			customMigration{version: 2},
+		)
+		require.NoError(t, err)
+
+		currentVersion := int64(0)
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		}
+
+		var history []migrate.HistoryEntry
+		transaction.recordHistory = func(ctx context.Context, entry migrate.HistoryEntry) error {
+			history = append(history, entry)
+			return nil
+		}
+		transaction.getHistory = func(ctx context.Context) ([]migrate.HistoryEntry, error) {
+			return history, nil
+		}
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.NoError(t, err)
+
+		err = migrator.Down(ctx, 1)
+		require.NoError(t, err)
+
+		got, err := migrator.History(ctx)
+		require.NoError(t, err)
+		require.Len(t, got, 3)
+		require.Equal(t, migrate.DirectionUp, got[0].Direction)
+		require.Equal(t, migrate.DirectionUp, got[1].Direction)
+		require.Equal(t, migrate.DirectionDown, got[2].Direction)
+		require.EqualValues(t, 2, got[2].Version)
+		require.False(t, got[0].AppliedAt.IsZero())
+	})
+
+	t.Run("error: recording history", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+		)
+		require.NoError(t, err)
+
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return 0, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			return nil
+		}
+
+		expectedErr := errors.New("history table missing")
+		transaction.recordHistory = func(ctx context.Context, entry migrate.HistoryEntry) error {
+			return expectedErr
+		}
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.ErrorIs(t, err, expectedErr)
+	})
+}
+
+func Test_Migrator_OutOfOrder(t *testing.T) {
+	transaction := appliedTransaction{}
+
+	conn := customConnection[appliedTransaction]{
+		transaction: func(ctx context.Context, handler func(tx appliedTransaction) error) error {
+			return handler(transaction)
+		},
+	}
+
+	migrations := []migrate.Migration[appliedTransaction]{
+		appliedMigration{version: 20241231},
+		appliedMigration{version: 20250101},
+		appliedMigration{version: 20250102},
+	}
+
+	setup := func(currentVersion int64, applied ...int64) *[]migrate.HistoryEntry {
+		transaction.applied = make(map[int64]bool)
+		for _, version := range applied {
+			transaction.applied[version] = true
+		}
+
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		}
+
+		history := &[]migrate.HistoryEntry{}
+		transaction.recordHistory = func(ctx context.Context, entry migrate.HistoryEntry) error {
+			*history = append(*history, entry)
+			return nil
+		}
+		return history
+	}
+
+	t.Run("success: default mode skips older migrations", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.NewWithOptions(conn, migrations)
+		require.NoError(t, err)
+
+		history := setup(20250101, 20250101)
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.NoError(t, err)
+		require.Len(t, *history, 1)
+		require.EqualValues(t, 20250102, (*history)[0].Version)
+	})
+
+	t.Run("success: applies migrations older than the current version", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithOutOfOrder())
+		require.NoError(t, err)
+
+		history := setup(20250101, 20250101)
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.NoError(t, err)
+		require.Len(t, *history, 2)
+		require.EqualValues(t, 20241231, (*history)[0].Version)
+		require.EqualValues(t, 20250102, (*history)[1].Version)
+		require.Len(t, transaction.applied, 3)
+
+		status, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 20250102, status.CurrentVersion)
+		require.Empty(t, status.Pending)
+	})
+
+	t.Run("success: redo only applies the reverted migration", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithOutOfOrder())
+		require.NoError(t, err)
+
+		history := setup(20250102, 20250101, 20250102)
+
+		err = migrator.Redo(ctx)
+		require.NoError(t, err)
+		require.Len(t, *history, 2)
+		require.Equal(t, migrate.DirectionDown, (*history)[0].Direction)
+		require.EqualValues(t, 20250102, (*history)[0].Version)
+		require.Equal(t, migrate.DirectionUp, (*history)[1].Direction)
+		require.EqualValues(t, 20250102, (*history)[1].Version)
+		require.False(t, transaction.applied[20241231])
+	})
+
+	t.Run("success: reverts applied migrations from the newest", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithOutOfOrder())
+		require.NoError(t, err)
+
+		history := setup(20250102, 20241231, 20250102)
+
+		err = migrator.Down(ctx, 0)
+		require.NoError(t, err)
+		require.Len(t, *history, 2)
+		require.EqualValues(t, 20250102, (*history)[0].Version)
+		require.EqualValues(t, 20241231, (*history)[1].Version)
+		require.Empty(t, transaction.applied)
+	})
+
+	t.Run("success: backfills applied versions from the current version", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithOutOfOrder())
+		require.NoError(t, err)
+
+		history := setup(20250101)
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.NoError(t, err)
+		require.Len(t, *history, 1)
+		require.EqualValues(t, 20250102, (*history)[0].Version)
+		require.Len(t, transaction.applied, 3)
+	})
+
+	t.Run("error: strict mode reports skipped migrations", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithStrictOrder())
+		require.NoError(t, err)
+
+		history := setup(20250101, 20250101)
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrOutOfOrder)
+
+		var outOfOrderErr *migrate.OutOfOrderError
+		require.ErrorAs(t, err, &outOfOrderErr)
+		require.Equal(t, []int64{20241231}, outOfOrderErr.Versions)
+		require.Empty(t, *history)
+	})
+
+	t.Run("error: versioner doesn't track applied versions", func(t *testing.T) {
+		ctx := t.Context()
+		conn := customConnection[customTransaction]{
+			transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+				return handler(customTransaction{
+					getCurrentVersion: func(ctx context.Context) (int64, error) {
+						return 0, nil
+					},
+				})
+			},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, []migrate.Migration[customTransaction]{
+			customMigration{version: 1},
+		}, migrate.WithOutOfOrder())
+		require.NoError(t, err)
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrAppliedVersionsNotSupported)
+	})
+}
+
+func Test_Migrator_Validate(t *testing.T) {
+	transaction := checksumTransaction{
+		checksums: make(map[int64]string),
+	}
+
+	conn := customConnection[checksumTransaction]{
+		transaction: func(ctx context.Context, handler func(tx checksumTransaction) error) error {
+			return handler(transaction)
+		},
+	}
+
+	currentVersion := int64(0)
+	transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+		return currentVersion, nil
+	}
+	transaction.setVersion = func(ctx context.Context, version int64) error {
+		currentVersion = version
+		return nil
+	}
+
+	t.Run("success: stores checksums of applied migrations", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			scriptMigration{version: 1, script: "CREATE TABLE a ()"},
+			scriptMigration{version: 2, script: "CREATE TABLE b ()"},
+		)
+		require.NoError(t, err)
+
+		err = migrator.Up(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, map[int64]string{1: "CREATE TABLE a ()"}, transaction.checksums)
+
+		err = migrator.Validate(ctx)
+		require.NoError(t, err)
+	})
+
+	t.Run("error: applied migration was edited", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.New(conn,
+			scriptMigration{version: 1, script: "CREATE TABLE a (id INT)"},
+			scriptMigration{version: 2, script: "CREATE TABLE b ()"},
+		)
+		require.NoError(t, err)
+
+		err = migrator.Validate(ctx)
+		require.ErrorIs(t, err, migrate.ErrChecksumMismatch)
+
+		var mismatchErr *migrate.ChecksumMismatchError
+		require.ErrorAs(t, err, &mismatchErr)
+		require.Equal(t, []int64{1}, mismatchErr.Versions)
+	})
+
+	t.Run("error: up refuses to run with edited migrations", func(t *testing.T) {
+		ctx := t.Context()
+		migrator, err := migrate.NewWithOptions(conn, []migrate.Migration[checksumTransaction]{
+			scriptMigration{version: 1, script: "CREATE TABLE a (id INT)"},
+			scriptMigration{version: 2, script: "CREATE TABLE b ()"},
+		}, migrate.WithChecksumValidation())
+		require.NoError(t, err)
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrChecksumMismatch)
+		require.EqualValues(t, 1, currentVersion)
+	})
+
+	t.Run("error: versioner doesn't store checksums", func(t *testing.T) {
+		ctx := t.Context()
+		conn := customConnection[customTransaction]{
+			transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+				return handler(customTransaction{})
+			},
+		}
+
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+		)
+		require.NoError(t, err)
+
+		err = migrator.Validate(ctx)
+		require.ErrorIs(t, err, migrate.ErrChecksumsNotSupported)
+	})
+}
+
+func Test_Migrator_Observer(t *testing.T) {
+	transaction := customTransaction{}
+
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(transaction)
+		},
+	}
+
+	type recorder struct {
+		before, after, failed, completed []migrate.Event
+	}
+
+	newHooks := func(r *recorder) migrate.Hooks {
+		return migrate.Hooks{
+			BeforeMigration: func(ctx context.Context, event migrate.Event) error {
+				r.before = append(r.before, event)
+				return nil
+			},
+			AfterMigration: func(ctx context.Context, event migrate.Event) {
+				r.after = append(r.after, event)
+			},
+			OnError: func(ctx context.Context, event migrate.Event) {
+				r.failed = append(r.failed, event)
+			},
+			OnComplete: func(ctx context.Context, event migrate.Event) {
+				r.completed = append(r.completed, event)
+			},
+		}
+	}
+
+	migrations := []migrate.Migration[customTransaction]{
+		customMigration{version: 1},
+		customMigration{version: 2},
+	}
+
+	t.Run("success: notifies every migration", func(t *testing.T) {
+		ctx := t.Context()
+		r := &recorder{}
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithHooks(newHooks(r)))
+		require.NoError(t, err)
+
+		currentVersion := int64(0)
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		}
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.NoError(t, err)
+
+		require.Len(t, r.before, 2)
+		require.Len(t, r.after, 2)
+		require.Empty(t, r.failed)
+		require.EqualValues(t, 1, r.after[0].Version)
+		require.Equal(t, migrate.DirectionUp, r.after[0].Direction)
+		require.EqualValues(t, 2, r.after[1].Version)
+
+		require.Len(t, r.completed, 1)
+		require.EqualValues(t, 2, r.completed[0].Version)
+		require.NoError(t, r.completed[0].Err)
+	})
+
+	t.Run("error: migration vetoed", func(t *testing.T) {
+		ctx := t.Context()
+		r := &recorder{}
+		hooks := newHooks(r)
+
+		vetoErr := errors.New("maintenance window closed")
+		hooks.BeforeMigration = func(ctx context.Context, event migrate.Event) error {
+			if event.Version == 2 {
+				return vetoErr
+			}
+			return nil
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithHooks(hooks))
+		require.NoError(t, err)
+
+		currentVersion := int64(0)
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		}
+
+		err = migrator.Up(ctx, migrate.Latest)
+		require.ErrorIs(t, err, vetoErr)
+		require.ErrorIs(t, err, migrate.ErrVetoed)
+		require.EqualValues(t, 1, currentVersion)
+
+		require.Len(t, r.after, 1)
+		require.Len(t, r.failed, 1)
+		require.EqualValues(t, 2, r.failed[0].Version)
+		require.ErrorIs(t, r.failed[0].Err, vetoErr)
+
+		require.Len(t, r.completed, 1)
+		require.EqualValues(t, 1, r.completed[0].Version)
+		require.ErrorIs(t, r.completed[0].Err, vetoErr)
+	})
+
+	t.Run("error: migration failed", func(t *testing.T) {
+		ctx := t.Context()
+		r := &recorder{}
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithHooks(newHooks(r)))
+		require.NoError(t, err)
+
+		expectedErr := errors.New("disk full")
+		transaction.getCurrentVersion = func(ctx context.Context) (int64, error) {
+			return 1, nil
+		}
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			return expectedErr
+		}
+
+		err = migrator.Down(ctx, 0)
+		require.ErrorIs(t, err, expectedErr)
+
+		require.Empty(t, r.after)
+		require.Len(t, r.failed, 1)
+		require.EqualValues(t, 1, r.failed[0].Version)
+		require.Equal(t, migrate.DirectionDown, r.failed[0].Direction)
+		require.Len(t, r.completed, 1)
+	})
+}
+
+func Test_Migrator_Logger(t *testing.T) {
+	var currentVersion int64
+
+	transaction := customTransaction{
+		getCurrentVersion: func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		},
+		setVersion: func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		},
+	}
+
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(transaction)
+		},
+	}
+
+	migrations := []migrate.Migration[customTransaction]{
+		customMigration{version: 1},
+	}
+
+	t.Run("success: logs applied migrations", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithLogger(logger))
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.NoError(t, err)
+
+		require.Contains(t, buf.String(), `msg="migration applied" version=1`)
+		require.Contains(t, buf.String(), `msg="migrations finished"`)
+	})
+
+	t.Run("success: nil logger discards", func(t *testing.T) {
+		currentVersion = 0
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithLogger(nil))
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.NoError(t, err)
+	})
+}
+
+func Test_Migrator_Tracing(t *testing.T) {
+	var currentVersion int64
+
+	transaction := customTransaction{
+		getCurrentVersion: func(ctx context.Context) (int64, error) {
+			return currentVersion, nil
+		},
+		setVersion: func(ctx context.Context, version int64) error {
+			currentVersion = version
+			return nil
+		},
+	}
+
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(transaction)
+		},
+	}
+
+	newMigrator := func(t *testing.T, migrations ...migrate.Migration[customTransaction]) (migrate.Migrator, *tracetest.SpanRecorder) {
+		recorder := tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithTracerProvider(provider))
+		require.NoError(t, err)
+		return migrator, recorder
+	}
+
+	t.Run("success: creates a span per migration", func(t *testing.T) {
+		currentVersion = 0
+		migrator, recorder := newMigrator(t, customMigration{version: 1}, customMigration{version: 2})
+
+		err := migrator.Up(t.Context(), migrate.Latest)
+		require.NoError(t, err)
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 3)
+
+		parent := spans[2]
+		require.Equal(t, "migrate.up", parent.Name())
+		require.Contains(t, parent.Attributes(), migrate.AttributeVersion.Int64(2))
+
+		for i, span := range spans[:2] {
+			require.Equal(t, "migrate.migration", span.Name())
+			require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+			require.Contains(t, span.Attributes(), migrate.AttributeVersion.Int64(int64(i+1)))
+			require.Contains(t, span.Attributes(), migrate.AttributeDirection.String(string(migrate.DirectionUp)))
+		}
+	})
+
+	t.Run("error: records the failed migration", func(t *testing.T) {
+		currentVersion = 0
+		transaction.setVersion = func(ctx context.Context, version int64) error {
+			if version == 2 {
+				return errors.New("set version failed")
+			}
+			currentVersion = version
+			return nil
+		}
+		migrator, recorder := newMigrator(t, customMigration{version: 1}, customMigration{version: 2})
+
+		err := migrator.Up(t.Context(), migrate.Latest)
+		require.Error(t, err)
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 3)
+		require.Equal(t, codes.Unset, spans[0].Status().Code)
+		require.Equal(t, codes.Error, spans[1].Status().Code)
+		require.Equal(t, codes.Error, spans[2].Status().Code)
+	})
+}
+
+type lockingConnection struct {
+	customConnection[customTransaction]
+	calls   []string
+	lockErr error
+}
+
+func (c *lockingConnection) Lock(ctx context.Context) error {
+	c.calls = append(c.calls, "lock")
+	return c.lockErr
+}
+
+func (c *lockingConnection) Unlock(ctx context.Context) error {
+	c.calls = append(c.calls, "unlock")
+	return nil
+}
+
+func (c *lockingConnection) ReadTransaction(ctx context.Context, handler func(tx customTransaction) error) error {
+	c.calls = append(c.calls, "read transaction")
+	return c.transaction(ctx, handler)
+}
+
+func Test_Migrator_Locker(t *testing.T) {
+	var currentVersion int64
+
+	newConnection := func() *lockingConnection {
+		currentVersion = 0
+
+		conn := &lockingConnection{}
+		conn.transaction = func(ctx context.Context, handler func(tx customTransaction) error) error {
+			conn.calls = append(conn.calls, "transaction")
+			return handler(customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return currentVersion, nil
+				},
+				setVersion: func(ctx context.Context, version int64) error {
+					currentVersion = version
+					return nil
+				},
+			})
+		}
+		return conn
+	}
+
+	migrations := []migrate.Migration[customTransaction]{
+		customMigration{version: 1},
+		customMigration{version: 2},
+	}
+
+	t.Run("success: database lock is held for the whole process", func(t *testing.T) {
+		conn := newConnection()
+		migrator, err := migrate.New(conn, migrations...)
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.NoError(t, err)
+		require.Equal(t, []string{"lock", "transaction", "transaction", "transaction", "unlock"}, conn.calls)
+	})
+
+	t.Run("success: custom locker replaces the database lock", func(t *testing.T) {
+		conn := newConnection()
+		lease := &memoryLease{}
+		locker := newLeaseLocker(t, lease, time.Minute)
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithLocker(locker))
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.NoError(t, err)
+		require.NotContains(t, conn.calls, "lock")
+		require.Empty(t, lease.holder())
+	})
+
+	t.Run("success: redo holds the lock once", func(t *testing.T) {
+		conn := newConnection()
+		currentVersion = 2
+
+		migrator, err := migrate.New(conn, migrations...)
+		require.NoError(t, err)
+
+		err = migrator.Redo(t.Context())
+		require.NoError(t, err)
+		require.EqualValues(t, 2, currentVersion)
+		require.Equal(t, []string{"lock", "transaction", "transaction", "unlock"}, conn.calls)
+	})
+
+	t.Run("success: goto chooses the direction under the lock", func(t *testing.T) {
+		conn := newConnection()
+		currentVersion = 2
+
+		migrator, err := migrate.New(conn, migrations...)
+		require.NoError(t, err)
+
+		_, err = migrator.Goto(t.Context(), 1)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, currentVersion)
+		require.Equal(t, []string{"lock", "read transaction", "transaction", "transaction", "transaction", "unlock"}, conn.calls)
+	})
+
+	t.Run("success: status reads without the lock", func(t *testing.T) {
+		conn := newConnection()
+		migrator, err := migrate.New(conn, migrations...)
+		require.NoError(t, err)
+
+		_, err = migrator.Status(t.Context())
+		require.NoError(t, err)
+
+		_, err = migrator.Plan(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.Equal(t, []string{"read transaction", "transaction", "read transaction", "transaction"}, conn.calls)
+	})
+
+	t.Run("error: lost lease cancels the running migration", func(t *testing.T) {
+		conn := newConnection()
+		lease := &memoryLease{}
+		locker := newLeaseLocker(t, lease, 50*time.Millisecond)
+
+		migrations := []migrate.Migration[customTransaction]{
+			blockingMigration{version: 1, up: func(ctx context.Context) error {
+				lease.steal("other")
+				<-ctx.Done()
+				return context.Cause(ctx)
+			}},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithLocker(locker))
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrLeaseLost)
+		require.Zero(t, currentVersion)
+	})
+
+	t.Run("error: lock failure stops the process", func(t *testing.T) {
+		conn := newConnection()
+		conn.lockErr = migrate.ErrLockTimeout
+
+		migrator, err := migrate.New(conn, migrations...)
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrLockTimeout)
+		require.Equal(t, []string{"lock"}, conn.calls)
+	})
+}
+
+func Test_Migrator_NonTransactional(t *testing.T) {
+	var (
+		currentVersion int64
+		dirty          dirtyState
+	)
+
+	newTransaction := func(inTransaction bool) dirtyTransaction {
+		return dirtyTransaction{
+			customTransaction: customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return currentVersion, nil
+				},
+				setVersion: func(ctx context.Context, version int64) error {
+					currentVersion = version
+					return nil
+				},
+			},
+			state:         &dirty,
+			inTransaction: inTransaction,
+		}
+	}
+
+	conn := nonTransactionalConnection[dirtyTransaction]{
+		customConnection: customConnection[dirtyTransaction]{
+			transaction: func(ctx context.Context, handler func(tx dirtyTransaction) error) error {
+				return handler(newTransaction(true))
+			},
+		},
+		withoutTransaction: func(ctx context.Context, handler func(tx dirtyTransaction) error) error {
+			return handler(newTransaction(false))
+		},
+	}
+
+	t.Run("success: migration runs outside a transaction", func(t *testing.T) {
+		currentVersion, dirty = 0, dirtyState{}
+
+		var (
+			ranInTransaction  bool
+			dirtyWhileRunning dirtyState
+		)
+
+		migrations := []migrate.Migration[dirtyTransaction]{
+			dirtyMigration{version: 1},
+			dirtyMigration{version: 2, noTransaction: true, up: func(tx dirtyTransaction) error {
+				ranInTransaction = tx.inTransaction
+				dirtyWhileRunning = *tx.state
+				return nil
+			}},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations)
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.NoError(t, err)
+		require.False(t, ranInTransaction)
+		require.Equal(t, dirtyState{version: 2, dirty: true}, dirtyWhileRunning)
+		require.Equal(t, dirtyState{}, dirty)
+		require.EqualValues(t, 2, currentVersion)
+	})
+
+	t.Run("error: failure leaves the version dirty", func(t *testing.T) {
+		currentVersion, dirty = 0, dirtyState{}
+
+		migrations := []migrate.Migration[dirtyTransaction]{
+			dirtyMigration{version: 1},
+			dirtyMigration{version: 2, noTransaction: true, up: func(tx dirtyTransaction) error {
+				return errors.New("index build failed")
+			}},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations)
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.Error(t, err)
+		require.Equal(t, dirtyState{version: 2, dirty: true}, dirty)
+		require.EqualValues(t, 1, currentVersion)
+	})
+
+	t.Run("error: database can't run outside a transaction", func(t *testing.T) {
+		currentVersion, dirty = 0, dirtyState{}
+
+		migrations := []migrate.Migration[dirtyTransaction]{
+			dirtyMigration{version: 1, noTransaction: true},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn.customConnection, migrations)
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrNoTransactionNotSupported)
+		require.Equal(t, dirtyState{}, dirty)
+	})
+
+	t.Run("error: versioner can't flag dirty versions", func(t *testing.T) {
+		var version int64
+
+		transaction := customTransaction{
+			getCurrentVersion: func(ctx context.Context) (int64, error) {
+				return version, nil
+			},
+			setVersion: func(ctx context.Context, v int64) error {
+				version = v
+				return nil
+			},
+		}
+
+		handler := func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(transaction)
+		}
+
+		conn := nonTransactionalConnection[customTransaction]{
+			customConnection:   customConnection[customTransaction]{transaction: handler},
+			withoutTransaction: handler,
+		}
+
+		migrations := []migrate.Migration[customTransaction]{
+			noTransactionMigration{customMigration{version: 1}},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations)
+		require.NoError(t, err)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrDirtyNotSupported)
+		require.Zero(t, version)
+	})
+}
+
+func Test_Migrator_Force(t *testing.T) {
+	var (
+		currentVersion int64
+		dirty          dirtyState
+		failing        = true
+	)
+
+	newTransaction := func(inTransaction bool) dirtyTransaction {
+		return dirtyTransaction{
+			customTransaction: customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return currentVersion, nil
+				},
+				setVersion: func(ctx context.Context, version int64) error {
+					currentVersion = version
+					return nil
+				},
+			},
+			state:         &dirty,
+			inTransaction: inTransaction,
+		}
+	}
+
+	conn := nonTransactionalConnection[dirtyTransaction]{
+		customConnection: customConnection[dirtyTransaction]{
+			transaction: func(ctx context.Context, handler func(tx dirtyTransaction) error) error {
+				return handler(newTransaction(true))
+			},
+		},
+		withoutTransaction: func(ctx context.Context, handler func(tx dirtyTransaction) error) error {
+			return handler(newTransaction(false))
+		},
+	}
+
+	migrations := []migrate.Migration[dirtyTransaction]{
+		dirtyMigration{version: 1},
+		dirtyMigration{version: 2, noTransaction: true, up: func(tx dirtyTransaction) error {
+			if failing {
+				return errors.New("index build failed")
+			}
+			return nil
+		}},
+		dirtyMigration{version: 3},
+	}
+
+	migrator, err := migrate.NewWithOptions(conn, migrations)
+	require.NoError(t, err)
+
+	t.Run("error: dirty database refuses to migrate", func(t *testing.T) {
+		err := migrator.Up(t.Context(), migrate.Latest)
+		require.Error(t, err)
+		require.EqualValues(t, 1, currentVersion)
+
+		failing = false
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+
+		var dirtyErr *migrate.DirtyError
+		require.ErrorAs(t, err, &dirtyErr)
+		require.ErrorIs(t, err, migrate.ErrDirty)
+		require.EqualValues(t, 2, dirtyErr.Version)
+
+		err = migrator.Down(t.Context(), migrate.Oldest)
+		require.ErrorIs(t, err, migrate.ErrDirty)
+		require.EqualValues(t, 1, currentVersion)
+
+		status, err := migrator.Status(t.Context())
+		require.NoError(t, err)
+		require.True(t, status.Dirty)
+		require.EqualValues(t, 2, status.DirtyVersion)
+	})
+
+	t.Run("success: force clears the dirty flag", func(t *testing.T) {
+		err := migrator.Force(t.Context(), 2)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, currentVersion)
+		require.Equal(t, dirtyState{}, dirty)
+
+		err = migrator.Up(t.Context(), migrate.Latest)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, currentVersion)
+	})
+
+	t.Run("error: forced version must be registered", func(t *testing.T) {
+		err := migrator.Force(t.Context(), 4)
+		require.ErrorIs(t, err, migrate.ErrMigrationNotFound)
+		require.EqualValues(t, 3, currentVersion)
+	})
+
+	t.Run("success: force updates the applied versions", func(t *testing.T) {
+		var version int64 = 3
+
+		transaction := appliedTransaction{
+			customTransaction: customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return version, nil
+				},
+				setVersion: func(ctx context.Context, v int64) error {
+					version = v
+					return nil
+				},
+			},
+			applied: map[int64]bool{1: true, 3: true},
+		}
+
+		conn := customConnection[appliedTransaction]{
+			transaction: func(ctx context.Context, handler func(tx appliedTransaction) error) error {
+				return handler(transaction)
+			},
+		}
+
+		migrator, err := migrate.New(conn,
+			appliedMigration{version: 1},
+			appliedMigration{version: 2},
+			appliedMigration{version: 3},
+		)
+		require.NoError(t, err)
+
+		err = migrator.Force(t.Context(), 2)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, version)
+		require.Equal(t, map[int64]bool{1: true, 2: true}, transaction.applied)
+	})
+}
+
+// newStepMigrator creates a migrator for versions 1, 2 and 3, starting at the given version.
+// It records every applied or reverted migration, such as "up 1" or "down 3".
+func newStepMigrator(t *testing.T, currentVersion int64) (migrate.Migrator, *int64, *[]string) {
+	var ran []string
+
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return currentVersion, nil
+				},
+				setVersion: func(ctx context.Context, version int64) error {
+					currentVersion = version
+					return nil
+				},
+			})
+		},
+	}
+
+	migrations := []migrate.Migration[customTransaction]{
+		customMigration{version: 1},
+		customMigration{version: 2},
+		customMigration{version: 3},
+	}
+
+	migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithHooks(migrate.Hooks{
+		AfterMigration: func(ctx context.Context, event migrate.Event) {
+			ran = append(ran, fmt.Sprintf("%s %d", event.Direction, event.Version))
+		},
+	}))
+	require.NoError(t, err)
+
+	return migrator, &currentVersion, &ran
+}
+
+func Test_Migrator_Steps(t *testing.T) {
+	t.Run("success: applies the next migrations", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 0)
+
+		err := migrator.Steps(t.Context(), 2)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, *version)
+		require.Equal(t, []string{"up 1", "up 2"}, *ran)
+	})
+
+	t.Run("success: reverts the last migrations", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 3)
+
+		err := migrator.Steps(t.Context(), -1)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, *version)
+		require.Equal(t, []string{"down 3"}, *ran)
+	})
+
+	t.Run("success: stops when there are no more migrations", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 2)
+
+		err := migrator.Steps(t.Context(), -5)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, *version)
+		require.Equal(t, []string{"down 2", "down 1"}, *ran)
+
+		err = migrator.Steps(t.Context(), 5)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, *version)
+	})
+
+	t.Run("success: zero steps does nothing", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 1)
+
+		err := migrator.Steps(t.Context(), 0)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, *version)
+		require.Empty(t, *ran)
+	})
+}
+
+func Test_Migrator_Goto(t *testing.T) {
+	t.Run("success: applies up to a newer version", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 1)
+
+		report, err := migrator.Goto(t.Context(), 3)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, *version)
+		require.Equal(t, []string{"up 2", "up 3"}, *ran)
+		require.Equal(t, migrate.DirectionUp, report.Direction)
+		require.Len(t, report.Applied(), 2)
+	})
+
+	t.Run("success: reverts down to an older version", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 3)
+
+		report, err := migrator.Goto(t.Context(), migrate.Zero)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, *version)
+		require.Equal(t, []string{"down 3", "down 2", "down 1"}, *ran)
+		require.Equal(t, migrate.DirectionDown, report.Direction)
+		require.Len(t, report.Applied(), 3)
+	})
+
+	t.Run("success: current version does nothing", func(t *testing.T) {
+		migrator, _, ran := newStepMigrator(t, 2)
+
+		report, err := migrator.Goto(t.Context(), 2)
+		require.NoError(t, err)
+		require.Empty(t, *ran)
+		require.Empty(t, report.Migrations)
+		require.EqualValues(t, 2, report.FinalVersion)
+	})
+
+	t.Run("error: version not found", func(t *testing.T) {
+		migrator, version, _ := newStepMigrator(t, 1)
+
+		_, err := migrator.Goto(t.Context(), 4)
+		require.ErrorIs(t, err, migrate.ErrMigrationNotFound)
+		require.EqualValues(t, 1, *version)
+	})
+}
+
+func Test_Migrator_Redo(t *testing.T) {
+	t.Run("success: reverts and applies the current version", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 2)
+
+		err := migrator.Redo(t.Context())
+		require.NoError(t, err)
+		require.EqualValues(t, 2, *version)
+		require.Equal(t, []string{"down 2", "up 2"}, *ran)
+	})
+
+	t.Run("error: no migrations applied", func(t *testing.T) {
+		migrator, _, ran := newStepMigrator(t, 0)
+
+		err := migrator.Redo(t.Context())
+		require.ErrorIs(t, err, migrate.ErrNoMigrations)
+		require.Empty(t, *ran)
+	})
+}
+
+func Test_Migrator_Irreversible(t *testing.T) {
+	var currentVersion int64
+
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			return handler(customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return currentVersion, nil
+				},
+				setVersion: func(ctx context.Context, version int64) error {
+					currentVersion = version
+					return nil
+				},
+			})
+		},
+	}
+
+	newMigrations := func(declared bool, downRan *bool) []migrate.Migration[customTransaction] {
+		return []migrate.Migration[customTransaction]{
+			customMigration{version: 1},
+			irreversibleMigration{version: 2, declared: declared, downRan: downRan},
+			customMigration{version: 3},
+		}
+	}
+
+	t.Run("error: down stops before an irreversible migration", func(t *testing.T) {
+		currentVersion = 3
+
+		var downRan bool
+
+		migrator, err := migrate.New(conn, newMigrations(true, &downRan)...)
+		require.NoError(t, err)
+
+		err = migrator.Down(t.Context(), migrate.Zero)
+
+		var irreversibleErr *migrate.IrreversibleError
+		require.ErrorAs(t, err, &irreversibleErr)
+		require.ErrorIs(t, err, migrate.ErrIrreversible)
+		require.EqualValues(t, 2, irreversibleErr.Version)
+		require.EqualValues(t, 2, currentVersion)
+		require.False(t, downRan)
+	})
+
+	t.Run("error: down returns ErrIrreversible", func(t *testing.T) {
+		currentVersion = 2
+
+		var downRan bool
+
+		migrator, err := migrate.New(conn, newMigrations(false, &downRan)...)
+		require.NoError(t, err)
+
+		err = migrator.Down(t.Context(), migrate.Zero)
+
+		var irreversibleErr *migrate.IrreversibleError
+		require.ErrorAs(t, err, &irreversibleErr)
+		require.EqualValues(t, 2, irreversibleErr.Version)
+		require.EqualValues(t, 2, currentVersion)
+		require.True(t, downRan)
+	})
+
+	t.Run("success: override moves past irreversible migrations", func(t *testing.T) {
+		for _, declared := range []bool{true, false} {
+			currentVersion = 3
+
+			var downRan bool
+
+			migrator, err := migrate.NewWithOptions(conn, newMigrations(declared, &downRan), migrate.WithIrreversibleOverride())
+			require.NoError(t, err)
+
+			report, err := migrator.Run(t.Context(), migrate.DirectionDown, migrate.Zero)
+			require.NoError(t, err)
+			require.EqualValues(t, 0, currentVersion)
+			require.Equal(t, !declared, downRan)
+			require.Equal(t, migrate.OutcomeSkipped, report.Migrations[1].Outcome)
+		}
+	})
+}
+
+func Test_Migrator_Plan(t *testing.T) {
+	t.Run("success: plans the migrations to apply", func(t *testing.T) {
+		migrator, version, ran := newStepMigrator(t, 1)
+
+		plan, err := migrator.Plan(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.Equal(t, []migrate.PlannedMigration{
+			{Version: 2, Direction: migrate.DirectionUp, NextVersion: 2},
+			{Version: 3, Direction: migrate.DirectionUp, NextVersion: 3},
+		}, plan)
+		require.EqualValues(t, 1, *version)
+		require.Empty(t, *ran)
+	})
+
+	t.Run("success: plans the migrations to revert", func(t *testing.T) {
+		migrator, _, _ := newStepMigrator(t, 3)
+
+		plan, err := migrator.Plan(t.Context(), migrate.DirectionDown, migrate.Zero)
+		require.NoError(t, err)
+		require.Equal(t, []migrate.PlannedMigration{
+			{Version: 3, Direction: migrate.DirectionDown, NextVersion: 2},
+			{Version: 2, Direction: migrate.DirectionDown, NextVersion: 1},
+			{Version: 1, Direction: migrate.DirectionDown, NextVersion: 0},
+		}, plan)
+	})
+
+	t.Run("error: unknown directions are refused", func(t *testing.T) {
+		migrator, _, _ := newStepMigrator(t, 3)
+
+		_, err := migrator.Plan(t.Context(), "UP", migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrInvalidDirection)
+
+		_, err = migrator.DryRun(t.Context(), "UP", migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrInvalidDirection)
+	})
+
+	t.Run("success: up-to-date database has an empty plan", func(t *testing.T) {
+		migrator, _, _ := newStepMigrator(t, 3)
+
+		plan, err := migrator.Plan(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.Empty(t, plan)
+	})
+
+	t.Run("success: plans skipped migrations out of order", func(t *testing.T) {
+		transaction := appliedTransaction{
+			customTransaction: customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return 3, nil
+				},
+			},
+			applied: map[int64]bool{1: true, 3: true},
+		}
+
+		conn := customConnection[appliedTransaction]{
+			transaction: func(ctx context.Context, handler func(tx appliedTransaction) error) error {
+				return handler(transaction)
+			},
+		}
+
+		migrations := []migrate.Migration[appliedTransaction]{
+			appliedMigration{version: 1},
+			appliedMigration{version: 2},
+			appliedMigration{version: 3},
+			appliedMigration{version: 4},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithOutOfOrder())
+		require.NoError(t, err)
+
+		plan, err := migrator.Plan(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.Equal(t, []migrate.PlannedMigration{
+			{Version: 2, Direction: migrate.DirectionUp, NextVersion: 3},
+			{Version: 4, Direction: migrate.DirectionUp, NextVersion: 4},
+		}, plan)
+		require.Equal(t, map[int64]bool{1: true, 3: true}, transaction.applied)
+	})
+
+	t.Run("error: plan reaches an irreversible migration", func(t *testing.T) {
+		conn := customConnection[customTransaction]{
+			transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+				return handler(customTransaction{
+					getCurrentVersion: func(ctx context.Context) (int64, error) {
+						return 2, nil
+					},
+				})
+			},
+		}
+
+		migrator, err := migrate.New(conn,
+			irreversibleMigration{version: 1, declared: true},
+			customMigration{version: 2},
+		)
+		require.NoError(t, err)
+
+		_, err = migrator.Plan(t.Context(), migrate.DirectionDown, migrate.Zero)
+		require.ErrorIs(t, err, migrate.ErrIrreversible)
+	})
+}
+
+func Test_Migrator_DryRun(t *testing.T) {
+	var committedVersion int64 = 1
+
+	// The connection only commits the version when the handler succeeds.
+	conn := customConnection[customTransaction]{
+		transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+			version := committedVersion
+
+			err := handler(customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return version, nil
+				},
+				setVersion: func(ctx context.Context, v int64) error {
+					version = v
+					return nil
+				},
+			})
+			if err == nil {
+				committedVersion = version
+			}
+			return err
+		},
+	}
+
+	t.Run("success: migrations run and are rolled back", func(t *testing.T) {
+		var applied []int64
+
+		migrations := []migrate.Migration[customTransaction]{
+			customMigration{version: 1},
+			customMigration{version: 2},
+			customMigration{version: 3},
+		}
+
+		migrator, err := migrate.NewWithOptions(conn, migrations, migrate.WithHooks(migrate.Hooks{
+			AfterMigration: func(ctx context.Context, event migrate.Event) {
+				applied = append(applied, event.Version)
+			},
+		}))
+		require.NoError(t, err)
+
+		ran, err := migrator.DryRun(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.Equal(t, []migrate.PlannedMigration{
+			{Version: 2, Direction: migrate.DirectionUp, NextVersion: 2},
+			{Version: 3, Direction: migrate.DirectionUp, NextVersion: 3},
+		}, ran)
+		require.EqualValues(t, 1, committedVersion)
+		require.Empty(t, applied)
+	})
+
+	t.Run("error: failed migration is reported", func(t *testing.T) {
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+			irreversibleMigration{version: 2, downRan: new(bool)},
+		)
+		require.NoError(t, err)
+
+		committedVersion = 2
+
+		ran, err := migrator.DryRun(t.Context(), migrate.DirectionDown, migrate.Zero)
+		require.ErrorIs(t, err, migrate.ErrIrreversible)
+		require.Empty(t, ran)
+		require.EqualValues(t, 2, committedVersion)
+	})
+
+	t.Run("error: migration outside a transaction", func(t *testing.T) {
+		committedVersion = 1
+
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+			noTransactionMigration{customMigration{version: 2}},
+		)
+		require.NoError(t, err)
+
+		_, err = migrator.DryRun(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrNoTransactionDryRun)
+		require.EqualValues(t, 1, committedVersion)
+	})
+}
+
+func Test_Migrator_MigrationError(t *testing.T) {
+	expectedErr := errors.New("connection reset")
+
+	newMigrator := func(t *testing.T, transaction customTransaction, commitErr error, migrations ...migrate.Migration[customTransaction]) migrate.Migrator {
+		conn := customConnection[customTransaction]{
+			transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+				if err := handler(transaction); err != nil {
+					return err
+				}
+				return commitErr
+			},
+		}
+
+		migrator, err := migrate.New(conn, migrations...)
+		require.NoError(t, err)
+		return migrator
+	}
+
+	readVersion := func(ctx context.Context) (int64, error) {
+		return 1, nil
+	}
+
+	tests := []struct {
+		name      string
+		migrator  func(t *testing.T) migrate.Migrator
+		version   int64
+		phase     migrate.Phase
+		sqlState  string
+		direction migrate.Direction
+	}{
+		{
+			name: "begin",
+			migrator: func(t *testing.T) migrate.Migrator {
+				conn := customConnection[customTransaction]{
+					transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+						return expectedErr
+					},
+				}
+				migrator, err := migrate.New(conn, customMigration{version: 1})
+				require.NoError(t, err)
+				return migrator
+			},
+			version: 0,
+			phase:   migrate.PhaseBegin,
+		},
+		{
+			name: "version read",
+			migrator: func(t *testing.T) migrate.Migrator {
+				transaction := customTransaction{
+					getCurrentVersion: func(ctx context.Context) (int64, error) {
+						return 0, expectedErr
+					},
+				}
+				return newMigrator(t, transaction, nil, customMigration{version: 1})
+			},
+			version: 0,
+			phase:   migrate.PhaseVersionRead,
+		},
+		{
+			name: "migration body",
+			migrator: func(t *testing.T) migrate.Migrator {
+				transaction := customTransaction{getCurrentVersion: readVersion}
+				return newMigrator(t, transaction, nil,
+					customMigration{version: 1},
+					failingMigration{version: 2, err: fmt.Errorf("statement 1: %w", sqlStateError{code: "42703"})},
+				)
+			},
+			version:  2,
+			phase:    migrate.PhaseMigration,
+			sqlState: "42703",
+		},
+		{
+			name: "version write",
+			migrator: func(t *testing.T) migrate.Migrator {
+				transaction := customTransaction{
+					getCurrentVersion: readVersion,
+					setVersion: func(ctx context.Context, version int64) error {
+						return expectedErr
+					},
+				}
+				return newMigrator(t, transaction, nil, customMigration{version: 1}, customMigration{version: 2})
+			},
+			version: 2,
+			phase:   migrate.PhaseVersionWrite,
+		},
+		{
+			name: "commit",
+			migrator: func(t *testing.T) migrate.Migrator {
+				transaction := customTransaction{
+					getCurrentVersion: readVersion,
+					setVersion: func(ctx context.Context, version int64) error {
+						return nil
+					},
+				}
+				return newMigrator(t, transaction, expectedErr, customMigration{version: 1}, customMigration{version: 2})
+			},
+			version: 2,
+			phase:   migrate.PhaseCommit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("error: "+tt.name, func(t *testing.T) {
+			err := tt.migrator(t).Up(t.Context(), migrate.Latest)
+
+			var migrationErr *migrate.MigrationError
+			require.ErrorAs(t, err, &migrationErr)
+			require.Equal(t, tt.version, migrationErr.Version)
+			require.Equal(t, migrate.DirectionUp, migrationErr.Direction)
+			require.Equal(t, tt.phase, migrationErr.Phase)
+			require.Equal(t, tt.sqlState, migrationErr.SQLState)
+		})
+	}
+
+	t.Run("error: planning errors aren't migration errors", func(t *testing.T) {
+		transaction := customTransaction{getCurrentVersion: readVersion}
+		migrator := newMigrator(t, transaction, nil, customMigration{version: 1})
+
+		err := migrator.Up(t.Context(), 2)
+		require.ErrorIs(t, err, migrate.ErrMigrationNotFound)
+
+		var migrationErr *migrate.MigrationError
+		require.False(t, errors.As(err, &migrationErr))
+	})
+}
+
+func Test_Migrator_Run(t *testing.T) {
+	t.Run("success: reports applied migrations", func(t *testing.T) {
+		migrator, currentVersion, _ := newStepMigrator(t, 1)
+
+		report, err := migrator.Run(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, *currentVersion)
+		require.Equal(t, migrate.DirectionUp, report.Direction)
+		require.EqualValues(t, 3, report.TargetVersion)
+		require.EqualValues(t, 1, report.InitialVersion)
+		require.EqualValues(t, 3, report.FinalVersion)
+		require.Len(t, report.Migrations, 2)
+		require.Len(t, report.Applied(), 2)
+		require.Empty(t, report.Pending)
+
+		for i, migration := range report.Migrations {
+			require.EqualValues(t, i+2, migration.Version)
+			require.Equal(t, migrate.OutcomeApplied, migration.Outcome)
+			require.False(t, migration.StartedAt.IsZero())
+		}
+
+		_, failed := report.Failed()
+		require.False(t, failed)
+	})
+
+	t.Run("success: reports reverted migrations", func(t *testing.T) {
+		migrator, _, _ := newStepMigrator(t, 3)
+
+		report, err := migrator.Run(t.Context(), migrate.DirectionDown, migrate.Zero)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, report.FinalVersion)
+		require.Len(t, report.Migrations, 3)
+		require.Equal(t, migrate.OutcomeReverted, report.Migrations[0].Outcome)
+	})
+
+	t.Run("error: unknown directions don't revert migrations", func(t *testing.T) {
+		migrator, currentVersion, ran := newStepMigrator(t, 3)
+
+		report, err := migrator.Run(t.Context(), "UP", migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrInvalidDirection)
+		require.NotContains(t, err.Error(), "upgrade failed")
+		require.Empty(t, report.Migrations)
+		require.EqualValues(t, 3, *currentVersion)
+		require.Empty(t, *ran)
+	})
+
+	t.Run("error: reports the failed migration and the pending ones", func(t *testing.T) {
+		currentVersion := int64(1)
+		expectedErr := errors.New("disk full")
+
+		conn := customConnection[customTransaction]{
+			transaction: func(ctx context.Context, handler func(tx customTransaction) error) error {
+				return handler(customTransaction{
+					getCurrentVersion: func(ctx context.Context) (int64, error) {
+						return currentVersion, nil
+					},
+					setVersion: func(ctx context.Context, version int64) error {
+						currentVersion = version
+						return nil
+					},
+				})
+			},
+		}
+
+		migrator, err := migrate.New(conn,
+			customMigration{version: 1},
+			customMigration{version: 2},
+			failingMigration{version: 3, err: expectedErr},
+			customMigration{version: 4},
+			customMigration{version: 5},
+		)
+		require.NoError(t, err)
+
+		report, err := migrator.Run(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.ErrorIs(t, err, expectedErr)
+		require.NotNil(t, report)
+		require.EqualValues(t, 2, report.FinalVersion)
+		require.Len(t, report.Applied(), 1)
+
+		failed, ok := report.Failed()
+		require.True(t, ok)
+		require.EqualValues(t, 3, failed.Version)
+		require.Equal(t, migrate.OutcomeFailed, failed.Outcome)
+		require.ErrorIs(t, failed.Err, expectedErr)
+
+		require.Equal(t, []migrate.PlannedMigration{
+			{Version: 4, Direction: migrate.DirectionUp, NextVersion: 4},
+			{Version: 5, Direction: migrate.DirectionUp, NextVersion: 5},
+		}, report.Pending)
+	})
+}
@@ -0,0 +1,78 @@
+package migrate
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// Observer receives notifications about the migration process.
+	// It can be used to plug logging, metrics or notifications into Up and Down.
+	// It's registered by WithObserver.
+	Observer interface {
+		// BeforeMigration is called before a migration runs, inside its transaction.
+		// Returning an error vetoes the migration, and stops the process.
+		BeforeMigration(ctx context.Context, event Event) error
+		// AfterMigration is called after a migration is applied or reverted, and its transaction is committed.
+		AfterMigration(ctx context.Context, event Event)
+		// OnError is called when a migration fails, or is vetoed.
+		OnError(ctx context.Context, event Event)
+		// OnComplete is called once Up or Down finishes, successfully or not.
+		// The event version is the database version after the process.
+		OnComplete(ctx context.Context, event Event)
+	}
+
+	// Event describes a migration, or a whole migration process, for an Observer.
+	Event struct {
+		// Version is the version of the migration.
+		Version int64
+		// Direction tells if the migration is being applied or reverted.
+		Direction Direction
+		// Duration is how long the migration took to run. It's zero on BeforeMigration.
+		Duration time.Duration
+		// Err is the error that stopped the migration. It's only set on OnError and OnComplete.
+		Err error
+	}
+
+	// Hooks is a set of functions called during the migration process, just like an Observer.
+	// Nil functions are ignored. It's registered by WithHooks.
+	Hooks struct {
+		BeforeMigration func(ctx context.Context, event Event) error
+		AfterMigration  func(ctx context.Context, event Event)
+		OnError         func(ctx context.Context, event Event)
+		OnComplete      func(ctx context.Context, event Event)
+	}
+
+	hooksObserver struct {
+		hooks Hooks
+	}
+)
+
+var (
+	_ Observer = hooksObserver{}
+)
+
+func (o hooksObserver) BeforeMigration(ctx context.Context, event Event) error {
+	if o.hooks.BeforeMigration == nil {
+		return nil
+	}
+	return o.hooks.BeforeMigration(ctx, event)
+}
+
+func (o hooksObserver) AfterMigration(ctx context.Context, event Event) {
+	if o.hooks.AfterMigration != nil {
+		o.hooks.AfterMigration(ctx, event)
+	}
+}
+
+func (o hooksObserver) OnError(ctx context.Context, event Event) {
+	if o.hooks.OnError != nil {
+		o.hooks.OnError(ctx, event)
+	}
+}
+
+func (o hooksObserver) OnComplete(ctx context.Context, event Event) {
+	if o.hooks.OnComplete != nil {
+		o.hooks.OnComplete(ctx, event)
+	}
+}
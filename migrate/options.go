@@ -0,0 +1,100 @@
+package migrate
+
+import (
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type ordering int
+
+const (
+	// orderingDefault applies only migrations newer than the current version.
+	orderingDefault ordering = iota
+	// orderingOutOfOrder applies every unapplied migration up to the target version.
+	orderingOutOfOrder
+	// orderingStrict fails when a migration older than the current version was never applied.
+	orderingStrict
+)
+
+// WithOutOfOrder makes Up apply every registered migration that was never applied, up to the target version,
+// even if it's older than the current version. Down reverts the applied migrations from the newest to the oldest.
+// It's useful when migrations from different branches are merged with interleaved versions.
+// The versioner must implement AppliedVersioner, or Up and Down will return ErrAppliedVersionsNotSupported.
+func WithOutOfOrder() Option {
+	return func(c *Config) {
+		c.ordering = orderingOutOfOrder
+	}
+}
+
+// WithStrictOrder makes Up fail with an OutOfOrderError when a registered migration older than
+// the current version was never applied, instead of silently skipping it.
+// The versioner must implement AppliedVersioner, or Up and Down will return ErrAppliedVersionsNotSupported.
+func WithStrictOrder() Option {
+	return func(c *Config) {
+		c.ordering = orderingStrict
+	}
+}
+
+// WithChecksumValidation makes Up and Down call Validate before running any migration,
+// refusing to run when an applied migration was edited since.
+func WithChecksumValidation() Option {
+	return func(c *Config) {
+		c.validateChecksums = true
+	}
+}
+
+// WithIrreversibleOverride makes Down move past irreversible migrations instead of stopping with an IrreversibleError.
+// Their Down isn't called: only the version moves back, as if they were reverted.
+// Use it when the changes were undone by other means, or can be kept.
+func WithIrreversibleOverride() Option {
+	return func(c *Config) {
+		c.irreversibleOverride = true
+	}
+}
+
+// WithObserver registers an observer, notified about every migration applied or reverted.
+// It can be used multiple times; observers are called in the order they were registered.
+func WithObserver(observer Observer) Option {
+	return func(c *Config) {
+		c.observers = append(c.observers, observer)
+	}
+}
+
+// WithHooks registers a set of functions, notified about every migration applied or reverted.
+func WithHooks(hooks Hooks) Option {
+	return WithObserver(hooksObserver{hooks: hooks})
+}
+
+// WithLogger sets the logger used to report the migration process.
+// By default, nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+		c.logger = logger
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used to trace the migration process.
+// Up and Down create a parent span, with a child span for each migration applied or reverted.
+// By default, nothing is traced.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *Config) {
+		if provider == nil {
+			provider = noop.NewTracerProvider()
+		}
+		c.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// WithLocker sets the lock acquired by Up and Down, for the whole process.
+// It replaces the lock provided by the Database, if it implements Locker.
+// See NewLeaseLocker for databases without native locks.
+func WithLocker(locker Locker) Option {
+	return func(c *Config) {
+		c.locker = locker
+	}
+}
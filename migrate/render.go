@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type (
+	// Script is the SQL rendered by Migrator.Render, to be reviewed and run by hand.
+	// It implements io.WriterTo, writing every statement in order.
+	Script struct {
+		// Setup lists the statements that prepare the database for the versioner.
+		// They also store the versions applied before they were tracked, as Up does.
+		Setup []string
+		// Migrations lists the rendered migrations, in the order they run.
+		Migrations []RenderedMigration
+	}
+
+	// RenderedMigration is a migration recorded by Migrator.Render.
+	RenderedMigration struct {
+		PlannedMigration
+		// NoTransaction tells if the statements must run outside a transaction.
+		NoTransaction bool
+		// Statements lists the statements of the migration, followed by the ones storing its version.
+		Statements []string
+	}
+)
+
+var _ io.WriterTo = (*Script)(nil)
+
+func (m migrator[T]) Render(ctx context.Context, direction Direction, targetVersion int64) (*Script, error) {
+	if err := checkDirection(direction); err != nil {
+		return nil, err
+	}
+
+	db, ok := any(m.conn).(RecordingDatabase[T])
+	if !ok {
+		return nil, ErrRenderNotSupported
+	}
+
+	plan, unmarked, err := m.plan(ctx, direction, targetVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	script := &Script{
+		Setup: db.SetupStatements(),
+	}
+
+	if len(unmarked) > 0 {
+		statements, err := db.Record(ctx, func(tx T) error {
+			return m.markUnmarked(ctx, tx, unmarked)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rendering applied versions: %w", err)
+		}
+		script.Setup = append(script.Setup, statements...)
+	}
+
+	var notRenderable []int64
+
+	for _, planned := range plan {
+		next := &step[T]{
+			migration:   m.migrations[m.findMigration(planned.Version)],
+			direction:   planned.Direction,
+			nextVersion: planned.NextVersion,
+		}
+
+		statements, err := db.Record(ctx, func(tx T) error {
+			if err := m.runMigration(ctx, tx, next); err != nil {
+				return err
+			}
+			return m.completeStep(ctx, tx, next, time.Now())
+		})
+		switch {
+		case errors.Is(err, ErrNotRenderable):
+			notRenderable = append(notRenderable, planned.Version)
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("rendering migration %d: %w", planned.Version, err)
+		}
+
+		script.Migrations = append(script.Migrations, RenderedMigration{
+			PlannedMigration: planned,
+			NoTransaction:    isNonTransactional(next.migration),
+			Statements:       statements,
+		})
+	}
+
+	if len(notRenderable) > 0 {
+		return nil, &NotRenderableError{Versions: notRenderable}
+	}
+
+	return script, nil
+}
+
+// WriteTo writes the setup statements, then each migration in its own transaction,
+// unless it must run outside a transaction.
+func (s *Script) WriteTo(w io.Writer) (int64, error) {
+	var builder strings.Builder
+
+	if len(s.Setup) > 0 {
+		builder.WriteString("-- Setup\n")
+		for _, statement := range s.Setup {
+			builder.WriteString(terminate(statement))
+		}
+	}
+
+	for _, migration := range s.Migrations {
+		if builder.Len() > 0 {
+			builder.WriteString("\n")
+		}
+
+		fmt.Fprintf(&builder, "-- Migration %d %s\n", migration.Version, migration.Direction)
+
+		if !migration.NoTransaction {
+			builder.WriteString("BEGIN;\n")
+		}
+
+		for _, statement := range migration.Statements {
+			builder.WriteString(terminate(statement))
+		}
+
+		if !migration.NoTransaction {
+			builder.WriteString("COMMIT;\n")
+		}
+	}
+
+	n, err := io.WriteString(w, builder.String())
+	return int64(n), err
+}
+
+// terminate ends the statement with a semicolon and a line break.
+// The semicolon goes on its own line when the statement ends with a comment.
+func terminate(statement string) string {
+	statement = strings.TrimSpace(statement)
+	if strings.HasSuffix(statement, ";") {
+		return statement + "\n"
+	}
+
+	lastLine := statement[strings.LastIndex(statement, "\n")+1:]
+	if strings.Contains(lastLine, "--") {
+		return statement + "\n;\n"
+	}
+
+	return statement + ";\n"
+}
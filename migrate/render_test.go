@@ -0,0 +1,251 @@
+package migrate_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTransaction records the statements of sqlMigration, and the version changes.
+type recordingTransaction struct {
+	customTransaction
+	statements *[]string
+}
+
+func (c recordingTransaction) Exec(statement string) {
+	*c.statements = append(*c.statements, statement)
+}
+
+func (c recordingTransaction) Query(query string) error {
+	if c.statements != nil {
+		return migrate.ErrNotRenderable
+	}
+	return nil
+}
+
+type recordingConnection struct {
+	customConnection[recordingTransaction]
+	currentVersion int64
+}
+
+func (c *recordingConnection) SetupStatements() []string {
+	return []string{"CREATE TABLE versions (version BIGINT)"}
+}
+
+func (c *recordingConnection) Record(ctx context.Context, handler func(tx recordingTransaction) error) ([]string, error) {
+	var statements []string
+
+	tx := recordingTransaction{
+		customTransaction: customTransaction{
+			setVersion: func(ctx context.Context, version int64) error {
+				statements = append(statements, fmt.Sprintf("UPDATE versions SET version = %d", version))
+				return nil
+			},
+		},
+		statements: &statements,
+	}
+
+	if err := handler(tx); err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+type sqlMigration struct {
+	version       int64
+	up            string
+	reads         bool
+	noTransaction bool
+}
+
+func (m sqlMigration) Up(ctx context.Context, tx recordingTransaction) error {
+	if m.reads {
+		if err := tx.Query("SELECT count(*) FROM users"); err != nil {
+			return err
+		}
+	}
+	tx.Exec(m.up)
+	return nil
+}
+
+func (m sqlMigration) Down(ctx context.Context, tx recordingTransaction) error {
+	return nil
+}
+
+func (m sqlMigration) Version() int64 {
+	return m.version
+}
+
+func (m sqlMigration) NoTransaction() bool {
+	return m.noTransaction
+}
+
+func newRecordingConnection(currentVersion int64) *recordingConnection {
+	conn := &recordingConnection{currentVersion: currentVersion}
+	conn.transaction = func(ctx context.Context, handler func(tx recordingTransaction) error) error {
+		return handler(recordingTransaction{
+			customTransaction: customTransaction{
+				getCurrentVersion: func(ctx context.Context) (int64, error) {
+					return conn.currentVersion, nil
+				},
+			},
+		})
+	}
+	return conn
+}
+
+// legacyTransaction tracks applied versions, in a database migrated before they were tracked.
+type legacyTransaction struct {
+	recordingTransaction
+}
+
+func (c legacyTransaction) GetAppliedVersions(ctx context.Context) ([]int64, error) {
+	return nil, nil
+}
+
+func (c legacyTransaction) MarkApplied(ctx context.Context, version int64) error {
+	c.Exec(fmt.Sprintf("INSERT INTO applied VALUES (%d)", version))
+	return nil
+}
+
+func (c legacyTransaction) MarkReverted(ctx context.Context, version int64) error {
+	c.Exec(fmt.Sprintf("DELETE FROM applied WHERE version = %d", version))
+	return nil
+}
+
+type legacyConnection struct {
+	*recordingConnection
+}
+
+func (c legacyConnection) Transaction(ctx context.Context, handler func(tx legacyTransaction) error) error {
+	return c.recordingConnection.Transaction(ctx, func(tx recordingTransaction) error {
+		return handler(legacyTransaction{tx})
+	})
+}
+
+func (c legacyConnection) Record(ctx context.Context, handler func(tx legacyTransaction) error) ([]string, error) {
+	return c.recordingConnection.Record(ctx, func(tx recordingTransaction) error {
+		return handler(legacyTransaction{tx})
+	})
+}
+
+type legacyMigration struct {
+	sqlMigration
+}
+
+func (m legacyMigration) Up(ctx context.Context, tx legacyTransaction) error {
+	return m.sqlMigration.Up(ctx, tx.recordingTransaction)
+}
+
+func (m legacyMigration) Down(ctx context.Context, tx legacyTransaction) error {
+	return m.sqlMigration.Down(ctx, tx.recordingTransaction)
+}
+
+func Test_Migrator_Render(t *testing.T) {
+	t.Run("success: renders the pending migrations", func(t *testing.T) {
+		conn := newRecordingConnection(1)
+
+		migrator, err := migrate.New[recordingTransaction](conn,
+			sqlMigration{version: 1, up: "CREATE TABLE users (id INT)"},
+			sqlMigration{version: 2, up: "ALTER TABLE users ADD COLUMN email TEXT;"},
+			sqlMigration{version: 3, up: "CREATE INDEX CONCURRENTLY users_email ON users (email) -- online", noTransaction: true},
+		)
+		require.NoError(t, err)
+
+		script, err := migrator.Render(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.Equal(t, []string{"CREATE TABLE versions (version BIGINT)"}, script.Setup)
+		require.Len(t, script.Migrations, 2)
+		require.Equal(t, []string{
+			"ALTER TABLE users ADD COLUMN email TEXT;",
+			"UPDATE versions SET version = 2",
+		}, script.Migrations[0].Statements)
+
+		var output strings.Builder
+		_, err = script.WriteTo(&output)
+		require.NoError(t, err)
+
+		expected := `-- Setup
+CREATE TABLE versions (version BIGINT);
+
+-- Migration 2 up
+BEGIN;
+ALTER TABLE users ADD COLUMN email TEXT;
+UPDATE versions SET version = 2;
+COMMIT;
+
+-- Migration 3 up
+CREATE INDEX CONCURRENTLY users_email ON users (email) -- online
+;
+UPDATE versions SET version = 3;
+`
+		require.Equal(t, expected, output.String())
+		require.EqualValues(t, 1, conn.currentVersion)
+	})
+
+	t.Run("success: stores the versions applied before they were tracked", func(t *testing.T) {
+		conn := legacyConnection{newRecordingConnection(2)}
+
+		migrator, err := migrate.New[legacyTransaction](conn,
+			legacyMigration{sqlMigration{version: 1, up: "CREATE TABLE users (id INT)"}},
+			legacyMigration{sqlMigration{version: 2, up: "ALTER TABLE users ADD COLUMN email TEXT"}},
+			legacyMigration{sqlMigration{version: 3, up: "CREATE INDEX users_email ON users (email)"}},
+		)
+		require.NoError(t, err)
+
+		script, err := migrator.Render(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"CREATE TABLE versions (version BIGINT)",
+			"INSERT INTO applied VALUES (1)",
+			"INSERT INTO applied VALUES (2)",
+		}, script.Setup)
+		require.Len(t, script.Migrations, 1)
+		require.Contains(t, script.Migrations[0].Statements, "INSERT INTO applied VALUES (3)")
+	})
+
+	t.Run("error: migrations reading data aren't renderable", func(t *testing.T) {
+		conn := newRecordingConnection(0)
+
+		migrator, err := migrate.New[recordingTransaction](conn,
+			sqlMigration{version: 1, up: "CREATE TABLE users (id INT)"},
+			sqlMigration{version: 2, up: "UPDATE users SET id = 1", reads: true},
+			sqlMigration{version: 3, up: "DELETE FROM users", reads: true},
+		)
+		require.NoError(t, err)
+
+		_, err = migrator.Render(t.Context(), migrate.DirectionUp, migrate.Latest)
+
+		var notRenderable *migrate.NotRenderableError
+		require.ErrorAs(t, err, &notRenderable)
+		require.Equal(t, []int64{2, 3}, notRenderable.Versions)
+	})
+
+	t.Run("error: unknown directions are refused", func(t *testing.T) {
+		conn := newRecordingConnection(1)
+
+		migrator, err := migrate.New[recordingTransaction](conn,
+			sqlMigration{version: 1, up: "CREATE TABLE users (id INT)"},
+		)
+		require.NoError(t, err)
+
+		_, err = migrator.Render(t.Context(), "UP", migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrInvalidDirection)
+	})
+
+	t.Run("error: database can't record statements", func(t *testing.T) {
+		conn := newRecordingConnection(0)
+
+		migrator, err := migrate.New[recordingTransaction](conn.customConnection,
+			sqlMigration{version: 1},
+		)
+		require.NoError(t, err)
+
+		_, err = migrator.Render(t.Context(), migrate.DirectionUp, migrate.Latest)
+		require.ErrorIs(t, err, migrate.ErrRenderNotSupported)
+	})
+}
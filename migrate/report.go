@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Outcome tells how a migration ended, in a Report.
+type Outcome string
+
+const (
+	// OutcomeApplied when the migration was applied.
+	OutcomeApplied Outcome = "applied"
+	// OutcomeReverted when the migration was reverted.
+	OutcomeReverted Outcome = "reverted"
+	// OutcomeSkipped when an irreversible migration was moved past, with WithIrreversibleOverride.
+	OutcomeSkipped Outcome = "skipped"
+	// OutcomeFailed when the migration failed, stopping the run.
+	OutcomeFailed Outcome = "failed"
+)
+
+type (
+	// Report describes a run of Migrator.Run. It's returned even when an error stops the run partway.
+	Report struct {
+		// Direction tells if the migrations were applied or reverted.
+		Direction Direction
+		// TargetVersion is the version the run was heading to, with Latest and Oldest resolved.
+		TargetVersion int64
+		// InitialVersion is the version of the database when the run started.
+		InitialVersion int64
+		// FinalVersion is the last known version of the database, after the last migration that ran.
+		FinalVersion int64
+		// StartedAt is when the run started.
+		StartedAt time.Time
+		// Duration is how long the run took, including acquiring the lock.
+		Duration time.Duration
+		// Migrations lists the migrations that ran, in order. When a migration fails, it's the last one.
+		Migrations []MigrationReport
+		// Pending lists the migrations left to run when an error stopped the run, as far as they can be planned.
+		Pending []PlannedMigration
+	}
+
+	// MigrationReport describes a single migration of a Report.
+	MigrationReport struct {
+		// Version is the version of the migration.
+		Version int64
+		// Direction tells if the migration was applied or reverted.
+		Direction Direction
+		// StartedAt is when the migration started running.
+		StartedAt time.Time
+		// Duration is how long the migration took to run.
+		Duration time.Duration
+		// Outcome tells how the migration ended.
+		Outcome Outcome
+		// Err is the error of a failed migration.
+		Err error
+	}
+)
+
+func (m migrator[T]) Run(ctx context.Context, direction Direction, targetVersion int64) (*Report, error) {
+	if err := checkDirection(direction); err != nil {
+		return &Report{Direction: direction, TargetVersion: targetVersion}, err
+	}
+
+	if len(m.migrations) == 0 {
+		return &Report{Direction: direction, TargetVersion: targetVersion}, ErrNoMigrations
+	}
+
+	report, err := m.handler(ctx, direction, m.resolveTarget(targetVersion), 0)
+	switch {
+	case err != nil && direction == DirectionDown:
+		return report, fmt.Errorf("rollback failed: %w", err)
+	case err != nil:
+		return report, fmt.Errorf("upgrade failed: %w", err)
+	}
+
+	return report, nil
+}
+
+// Applied returns the migrations of the report that ran successfully, either applied, reverted or skipped.
+func (r *Report) Applied() []MigrationReport {
+	applied := make([]MigrationReport, 0, len(r.Migrations))
+
+	for _, migration := range r.Migrations {
+		if migration.Outcome != OutcomeFailed {
+			applied = append(applied, migration)
+		}
+	}
+
+	return applied
+}
+
+// Failed returns the migration that stopped the run, if any.
+func (r *Report) Failed() (MigrationReport, bool) {
+	if len(r.Migrations) == 0 || r.Migrations[len(r.Migrations)-1].Outcome != OutcomeFailed {
+		return MigrationReport{}, false
+	}
+
+	return r.Migrations[len(r.Migrations)-1], true
+}
+
+// report describes the outcome of the step, for a Report.
+func (s step[T]) report(startedAt time.Time, err error) MigrationReport {
+	migration := MigrationReport{
+		Version:   s.migration.Version(),
+		Direction: s.direction,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt),
+		Outcome:   OutcomeApplied,
+		Err:       err,
+	}
+
+	switch {
+	case err != nil:
+		migration.Outcome = OutcomeFailed
+	case s.skipped:
+		migration.Outcome = OutcomeSkipped
+	case s.direction == DirectionDown:
+		migration.Outcome = OutcomeReverted
+	}
+
+	return migration
+}
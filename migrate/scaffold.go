@@ -0,0 +1,309 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// VersionScheme is how the versions of a migration directory are numbered.
+type VersionScheme string
+
+const (
+	// VersionSequential numbers migrations 0001, 0002, and so on.
+	VersionSequential VersionScheme = "sequential"
+	// VersionTimestamp numbers migrations with their UTC creation time, such as 20250103150405.
+	VersionTimestamp VersionScheme = "timestamp"
+)
+
+// timestampLayout formats the versions of the VersionTimestamp scheme.
+const timestampLayout = "20060102150405"
+
+// defaultSequentialWidth is the zero-padded width of sequential versions, in a directory without migrations.
+const defaultSequentialWidth = 4
+
+// defaultGoPackage is the package of Go migrations, in a directory without Go files.
+const defaultGoPackage = "migrations"
+
+type (
+	// ScaffoldConfig describes the migration created by NewScaffold.
+	ScaffoldConfig struct {
+		// Name describes the migration, such as "create users". It's written as create_users in the file names.
+		Name string
+		// Scheme numbers the migration when the directory has none yet. It defaults to VersionSequential.
+		// Otherwise, the scheme of the existing migrations is kept.
+		Scheme VersionScheme
+		// Go creates a Go migration for an adapter, instead of a pair of SQL scripts.
+		Go *GoStub
+		// Now is the creation time, for timestamp versions. It defaults to the current time.
+		Now time.Time
+	}
+
+	// GoStub describes the Go migrations of an adapter, such as adapter.GoStub of the pq and pgx adapters.
+	GoStub struct {
+		// Package of the file. When empty, it's read from the Go files of the directory, or defaults to migrations.
+		Package string
+		// Imports are the packages used by Versioner.
+		Imports []string
+		// Versioner is the type given to Up and Down, such as *adapter.Versioner.
+		Versioner string
+	}
+
+	// Scaffold is a new migration, with the files to create.
+	Scaffold struct {
+		// Version of the new migration, greater than every version of the directory.
+		Version int64
+		// Name is the description of the migration in the file names.
+		Name string
+		// Files to create, with paths relative to the file system given to NewScaffold.
+		Files []ScaffoldFile
+	}
+
+	// ScaffoldFile is a file of a new migration.
+	ScaffoldFile struct {
+		Path    string
+		Content []byte
+	}
+)
+
+var (
+	// migrationFilePattern matches the files carrying a version, such as 0001_create_users.up.sql or 0001_create_users.go.
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_.+\.(?:sql|go)$`)
+	// nameSeparatorPattern matches the characters replaced by underscores in migration names.
+	nameSeparatorPattern = regexp.MustCompile(`[^a-z0-9]+`)
+	// packageClausePattern matches the package clause of a Go file.
+	packageClausePattern = regexp.MustCompile(`(?m)^package\s+(\w+)`)
+)
+
+var goStubTemplate = template.Must(template.New("stub").Parse(`package {{.Package}}
+
+import (
+	"context"
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+)
+
+type {{.Type}} struct{}
+
+func (m *{{.Type}}) Version() int64 {
+	return {{.Version}}
+}
+
+func (m *{{.Type}}) Up(ctx context.Context, tx {{.Versioner}}) error {
+	return nil
+}
+
+func (m *{{.Type}}) Down(ctx context.Context, tx {{.Versioner}}) error {
+	return nil
+}
+`))
+
+// NewScaffold prepares a new migration for the directory of the file system.
+// Its version is greater than every version found in the file names of the directory, numbered with the same scheme:
+// sequential versions keep their zero-padded width, and timestamp versions use the UTC creation time.
+// The migration is a pair of NNNN_name.up.sql and NNNN_name.down.sql scripts,
+// or a NNNN_name.go file declaring a migration_NNNN type when config.Go is set.
+// Nothing is written: use Write to create the files.
+func NewScaffold(fileSystem fs.FS, dir string, config ScaffoldConfig) (*Scaffold, error) {
+	name := strings.Trim(nameSeparatorPattern.ReplaceAllString(strings.ToLower(config.Name), "_"), "_")
+	if name == "" {
+		return nil, fmt.Errorf("%w: %q", ErrMigrationName, config.Name)
+	}
+
+	entries, err := fs.ReadDir(fileSystem, dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading migrations directory: %w", err)
+	}
+
+	prefix, err := nextVersion(entries, config)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing version %s: %w", prefix, err)
+	}
+
+	scaffold := &Scaffold{
+		Version: version,
+		Name:    name,
+	}
+
+	base := path.Join(dir, prefix+"_"+name)
+
+	if config.Go == nil {
+		scaffold.Files = []ScaffoldFile{
+			{Path: base + ".up.sql", Content: []byte("-- Write the statements applying the migration.\n")},
+			{Path: base + ".down.sql", Content: []byte("-- Write the statements reverting the migration, or leave it empty if it's irreversible.\n")},
+		}
+		return scaffold, nil
+	}
+
+	content, err := config.Go.render(fileSystem, dir, entries, prefix, version)
+	if err != nil {
+		return nil, err
+	}
+
+	scaffold.Files = []ScaffoldFile{{Path: base + ".go", Content: content}}
+
+	return scaffold, nil
+}
+
+// nextVersion returns the formatted version following the versions of the directory entries.
+func nextVersion(entries []fs.DirEntry, config ScaffoldConfig) (string, error) {
+	var (
+		last       int64
+		lastPrefix string
+	)
+
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s: version must be an integer: %w", entry.Name(), ErrScriptName)
+		}
+
+		if version >= last {
+			last, lastPrefix = version, match[1]
+		}
+	}
+
+	scheme := config.Scheme
+	var lastTime time.Time
+	if lastPrefix != "" {
+		scheme = VersionSequential
+		if parsed, err := time.Parse(timestampLayout, lastPrefix); err == nil {
+			scheme, lastTime = VersionTimestamp, parsed
+		}
+	}
+
+	switch scheme {
+	case VersionTimestamp:
+		now := config.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+
+		next := now.UTC().Truncate(time.Second)
+
+		// Migrations created within the same second, or with a clock behind, still get increasing versions.
+		if following := lastTime.Add(time.Second); !lastTime.IsZero() && next.Before(following) {
+			next = following
+		}
+		return next.Format(timestampLayout), nil
+	case VersionSequential, "":
+		width := len(lastPrefix)
+		if width == 0 {
+			width = defaultSequentialWidth
+		}
+		return fmt.Sprintf("%0*d", width, last+1), nil
+	default:
+		return "", fmt.Errorf("unknown version scheme %q", scheme)
+	}
+}
+
+// render generates the Go file of the migration.
+func (s *GoStub) render(fileSystem fs.FS, dir string, entries []fs.DirEntry, prefix string, version int64) ([]byte, error) {
+	pkg := s.Package
+	if pkg == "" {
+		pkg = detectPackage(fileSystem, dir, entries)
+	}
+
+	var buf bytes.Buffer
+
+	err := goStubTemplate.Execute(&buf, map[string]any{
+		"Package":   pkg,
+		"Imports":   s.Imports,
+		"Type":      "migration_" + prefix,
+		"Version":   version,
+		"Versioner": s.Versioner,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("generating Go migration: %w", err)
+	}
+
+	content, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting Go migration: %w", err)
+	}
+
+	return content, nil
+}
+
+// detectPackage returns the package of the Go files in the directory, ignoring tests.
+func detectPackage(fileSystem fs.FS, dir string, entries []fs.DirEntry) string {
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".go" || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		content, err := fs.ReadFile(fileSystem, path.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		if match := packageClausePattern.FindSubmatch(content); match != nil {
+			return string(match[1])
+		}
+	}
+
+	return defaultGoPackage
+}
+
+// Write creates the files of the migration, with paths relative to the root directory, creating it if needed.
+// It fails without overwriting anything if a file already exists, such as when another migration was created meanwhile.
+func (s *Scaffold) Write(root string) error {
+	var created []string
+
+	for _, file := range s.Files {
+		filePath := filepath.Join(root, filepath.FromSlash(file.Path))
+
+		err := writeNewFile(filePath, file.Content)
+		if err != nil {
+			// Don't leave half of a migration behind.
+			for _, createdPath := range created {
+				_ = os.Remove(createdPath)
+			}
+			return err
+		}
+
+		created = append(created, filePath)
+	}
+
+	return nil
+}
+
+func writeNewFile(filePath string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return fmt.Errorf("creating migrations directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating migration file: %w", err)
+	}
+
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		_ = os.Remove(filePath)
+		return fmt.Errorf("writing %s: %w", filePath, err)
+	}
+
+	return file.Close()
+}
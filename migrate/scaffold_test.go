@@ -0,0 +1,161 @@
+package migrate_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewScaffold(t *testing.T) {
+	now := time.Date(2025, 1, 3, 15, 4, 5, 0, time.FixedZone("UTC-3", -3*60*60))
+
+	t.Run("success: continues sequential versions", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"migrations/0001_create_users.up.sql":   {},
+			"migrations/0001_create_users.down.sql": {},
+			"migrations/0002_add_email.sql":         {},
+			"migrations/0007_backfill.go":           {},
+			"migrations/0009_helpers_test.go":       {},
+		}
+
+		scaffold, err := migrate.NewScaffold(fileSystem, "migrations", migrate.ScaffoldConfig{Name: "Add Orders!"})
+		require.NoError(t, err)
+		require.EqualValues(t, 8, scaffold.Version)
+		require.Equal(t, "add_orders", scaffold.Name)
+		require.Len(t, scaffold.Files, 2)
+		require.Equal(t, "migrations/0008_add_orders.up.sql", scaffold.Files[0].Path)
+		require.Equal(t, "migrations/0008_add_orders.down.sql", scaffold.Files[1].Path)
+	})
+
+	t.Run("success: continues timestamp versions in UTC", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"20241231120000_create_users.up.sql": {},
+		}
+
+		scaffold, err := migrate.NewScaffold(fileSystem, ".", migrate.ScaffoldConfig{Name: "add_orders", Now: now})
+		require.NoError(t, err)
+		require.EqualValues(t, 20250103180405, scaffold.Version)
+		require.Equal(t, "20250103180405_add_orders.up.sql", scaffold.Files[0].Path)
+	})
+
+	t.Run("success: timestamp versions stay increasing", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"20250103180405_create_users.up.sql": {},
+		}
+
+		scaffold, err := migrate.NewScaffold(fileSystem, ".", migrate.ScaffoldConfig{Name: "add_orders", Now: now})
+		require.NoError(t, err)
+		require.EqualValues(t, 20250103180406, scaffold.Version)
+	})
+
+	t.Run("success: timestamp versions roll over to the next day", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"20250103235959_create_users.up.sql": {},
+		}
+
+		scaffold, err := migrate.NewScaffold(fileSystem, ".", migrate.ScaffoldConfig{Name: "add_orders", Now: now})
+		require.NoError(t, err)
+		require.EqualValues(t, 20250104000000, scaffold.Version)
+	})
+
+	t.Run("success: empty directory uses the configured scheme", func(t *testing.T) {
+		scaffold, err := migrate.NewScaffold(fstest.MapFS{}, "migrations", migrate.ScaffoldConfig{Name: "init"})
+		require.NoError(t, err)
+		require.Equal(t, "migrations/0001_init.up.sql", scaffold.Files[0].Path)
+
+		scaffold, err = migrate.NewScaffold(fstest.MapFS{}, "migrations", migrate.ScaffoldConfig{
+			Name:   "init",
+			Scheme: migrate.VersionTimestamp,
+			Now:    now,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "migrations/20250103180405_init.up.sql", scaffold.Files[0].Path)
+	})
+
+	t.Run("success: Go migration", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"0001_create_users.go":  {Data: []byte("// Package schema migrates the database.\npackage schema\n")},
+			"migrations_test.go":    {Data: []byte("package schema_test\n")},
+			"0002_add_email.up.sql": {},
+		}
+
+		scaffold, err := migrate.NewScaffold(fileSystem, ".", migrate.ScaffoldConfig{
+			Name: "add orders",
+			Go: &migrate.GoStub{
+				Imports:   []string{"database/sql", "github.com/sonalys/codemigrate/database/postgres/pq/adapter"},
+				Versioner: "*adapter.Versioner[*sql.Tx]",
+			},
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 3, scaffold.Version)
+		require.Len(t, scaffold.Files, 1)
+		require.Equal(t, "0003_add_orders.go", scaffold.Files[0].Path)
+		require.Equal(t, `package schema
+
+import (
+	"context"
+	"database/sql"
+	"github.com/sonalys/codemigrate/database/postgres/pq/adapter"
+)
+
+type migration_0003 struct{}
+
+func (m *migration_0003) Version() int64 {
+	return 3
+}
+
+func (m *migration_0003) Up(ctx context.Context, tx *adapter.Versioner[*sql.Tx]) error {
+	return nil
+}
+
+func (m *migration_0003) Down(ctx context.Context, tx *adapter.Versioner[*sql.Tx]) error {
+	return nil
+}
+`, string(scaffold.Files[0].Content))
+	})
+
+	t.Run("error: invalid name", func(t *testing.T) {
+		_, err := migrate.NewScaffold(fstest.MapFS{}, ".", migrate.ScaffoldConfig{Name: " -- "})
+		require.ErrorIs(t, err, migrate.ErrMigrationName)
+	})
+}
+
+func TestScaffold_Write(t *testing.T) {
+	t.Run("success: creates the files", func(t *testing.T) {
+		root := t.TempDir()
+
+		scaffold, err := migrate.NewScaffold(os.DirFS(root), "migrations", migrate.ScaffoldConfig{Name: "init"})
+		require.NoError(t, err)
+		require.NoError(t, scaffold.Write(root))
+
+		content, err := os.ReadFile(filepath.Join(root, "migrations", "0001_init.up.sql"))
+		require.NoError(t, err)
+		require.Equal(t, scaffold.Files[0].Content, content)
+
+		scaffold, err = migrate.NewScaffold(os.DirFS(root), "migrations", migrate.ScaffoldConfig{Name: "init"})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, scaffold.Version)
+	})
+
+	t.Run("error: doesn't overwrite files", func(t *testing.T) {
+		root := t.TempDir()
+
+		scaffold, err := migrate.NewScaffold(os.DirFS(root), ".", migrate.ScaffoldConfig{Name: "init"})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, "0001_init.down.sql"), []byte("DROP TABLE users"), 0o644))
+
+		require.ErrorIs(t, scaffold.Write(root), os.ErrExist)
+
+		_, err = os.Stat(filepath.Join(root, "0001_init.up.sql"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		content, err := os.ReadFile(filepath.Join(root, "0001_init.down.sql"))
+		require.NoError(t, err)
+		require.Equal(t, "DROP TABLE users", string(content))
+	})
+}
@@ -0,0 +1,116 @@
+package migrate
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ScriptFiles is a migration made of SQL scripts, found by FindScripts.
+type ScriptFiles struct {
+	// Version is parsed from the leading digits of the file names.
+	Version int64
+	// Name is the description following the version, such as "create_users" in 0001_create_users.up.sql.
+	Name string
+	// UpPath is the path of the up script.
+	// For a single NNNN_description.sql file, it's the path of that file, carrying both directions in its sections.
+	UpPath string
+	// DownPath is the path of the down script. It's empty when the migration has no down script.
+	DownPath string
+}
+
+// scriptNamePattern matches the migration script names, such as 0001_create_users.up.sql or 0001_create_users.sql.
+var scriptNamePattern = regexp.MustCompile(`^(\d+)_(.+?)(?:\.(up|down))?\.sql$`)
+
+// FindScripts lists the migration scripts in the directory of the file system, sorted by version.
+// Scripts are named NNNN_description.up.sql and NNNN_description.down.sql. Other files and subdirectories are ignored.
+// A single NNNN_description.sql file takes the place of the up script, and may carry the down script in a Down section.
+// A migration without a down script is irreversible.
+// Scripts with invalid names, down scripts without an up script, and versions with different descriptions
+// or duplicated scripts are all reported in a single error, wrapping ErrInvalidScripts.
+func FindScripts(fileSystem fs.FS, dir string) ([]ScriptFiles, error) {
+	entries, err := fs.ReadDir(fileSystem, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations directory: %w", err)
+	}
+
+	var (
+		scripts  = make(map[int64]*ScriptFiles)
+		problems []error
+	)
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		filePath := path.Join(dir, entry.Name())
+
+		match := scriptNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			problems = append(problems, fmt.Errorf("%s: %w", filePath, ErrScriptName))
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			problems = append(problems, fmt.Errorf("%s: version must be a positive integer: %w", filePath, ErrScriptName))
+			continue
+		}
+
+		name, direction := match[2], match[3]
+
+		script, ok := scripts[version]
+		if !ok {
+			script = &ScriptFiles{Version: version, Name: name}
+			scripts[version] = script
+		}
+
+		if script.Name != name {
+			problems = append(problems, fmt.Errorf("%s: %w: %q and %q", filePath, ErrMismatchedScripts, script.Name, name))
+			continue
+		}
+
+		// A single file takes the place of the up script, so it can't be mixed with one.
+		target := &script.UpPath
+		if direction == "down" {
+			target = &script.DownPath
+		}
+
+		if *target != "" {
+			problems = append(problems, fmt.Errorf("%s: %w: version %d", filePath, ErrDuplicateMigration, version))
+			continue
+		}
+		*target = filePath
+	}
+
+	found := make([]ScriptFiles, 0, len(scripts))
+
+	for _, script := range scripts {
+		if script.UpPath == "" {
+			problems = append(problems, fmt.Errorf("%s: %w", script.DownPath, ErrOrphanedScript))
+			continue
+		}
+		found = append(found, *script)
+	}
+
+	if len(problems) > 0 {
+		// Orphaned scripts are found in random order.
+		slices.SortFunc(problems, func(a, b error) int {
+			return strings.Compare(a.Error(), b.Error())
+		})
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScripts, errors.Join(problems...))
+	}
+
+	slices.SortFunc(found, func(a, b ScriptFiles) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return found, nil
+}
@@ -0,0 +1,73 @@
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindScripts(t *testing.T) {
+	t.Run("success: pairs scripts by version", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"migrations/0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT")},
+			"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT)")},
+			"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+			"migrations/0003_add_orders.sql":        {Data: []byte("-- +codemigrate Up\nCREATE TABLE orders (id INT)")},
+			"migrations/README.md":                  {Data: []byte("ignored")},
+			"migrations/seeds/0003_seed.up.sql":     {Data: []byte("ignored")},
+		}
+
+		scripts, err := migrate.FindScripts(fileSystem, "migrations")
+		require.NoError(t, err)
+		require.Equal(t, []migrate.ScriptFiles{
+			{
+				Version:  1,
+				Name:     "create_users",
+				UpPath:   "migrations/0001_create_users.up.sql",
+				DownPath: "migrations/0001_create_users.down.sql",
+			},
+			{
+				Version: 2,
+				Name:    "add_email",
+				UpPath:  "migrations/0002_add_email.up.sql",
+			},
+			{
+				Version: 3,
+				Name:    "add_orders",
+				UpPath:  "migrations/0003_add_orders.sql",
+			},
+		}, scripts)
+	})
+
+	t.Run("error: reports every invalid script", func(t *testing.T) {
+		fileSystem := fstest.MapFS{
+			"0001_create_users.up.sql":   {},
+			"0001_create_users.down.sql": {},
+			"0002_add_email.up.sql":      {},
+			"0002_add_mail.down.sql":     {},
+			"0003_drop_legacy.down.sql":  {},
+			"0004_add_index.up.sql":      {},
+			"04_add_index.up.sql":        {},
+			"create_orders.sql":          {},
+			"0005_seed.sql":              {},
+			"0005_seed.up.sql":           {},
+		}
+
+		_, err := migrate.FindScripts(fileSystem, ".")
+		require.ErrorIs(t, err, migrate.ErrInvalidScripts)
+		require.ErrorIs(t, err, migrate.ErrScriptName)
+		require.ErrorIs(t, err, migrate.ErrMismatchedScripts)
+		require.ErrorIs(t, err, migrate.ErrOrphanedScript)
+		require.ErrorIs(t, err, migrate.ErrDuplicateMigration)
+		require.ErrorContains(t, err, "create_orders.sql")
+		require.ErrorContains(t, err, "0003_drop_legacy.down.sql")
+		require.ErrorContains(t, err, "version 5")
+	})
+
+	t.Run("error: directory not found", func(t *testing.T) {
+		_, err := migrate.FindScripts(fstest.MapFS{}, "migrations")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,194 @@
+package sqlscript
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// scanner walks a piece of script, tracking the line and column of its position.
+type scanner struct {
+	src    string
+	pos    int
+	line   int
+	column int
+}
+
+// split splits the chunk into statements, at semicolons outside of quotes, dollar quotes and comments.
+// With whole set, the chunk is a single statement, only stripped of leading comments and whitespace.
+// line is the line of the script where the chunk starts.
+func split(chunk string, line int, whole bool) []Statement {
+	var (
+		s          = &scanner{src: chunk, line: line, column: 1}
+		statements []Statement
+		current    *Statement
+		start      int
+	)
+
+	emit := func(end int) {
+		current.SQL = strings.TrimRightFunc(chunk[start:end], unicode.IsSpace)
+		statements = append(statements, *current)
+		current = nil
+	}
+
+	for s.pos < len(chunk) {
+		switch {
+		case isSpace(chunk[s.pos]):
+			s.advance(1)
+			continue
+		case strings.HasPrefix(chunk[s.pos:], "--"):
+			s.skipLineComment()
+			continue
+		case strings.HasPrefix(chunk[s.pos:], "/*"):
+			s.skipBlockComment()
+			continue
+		}
+
+		// Comments and whitespace before a statement are left out of it.
+		if current == nil {
+			current = &Statement{Line: s.line, Column: s.column}
+			start = s.pos
+		}
+
+		switch c := chunk[s.pos]; {
+		case c == ';' && !whole:
+			s.advance(1)
+			emit(s.pos)
+		case c == '\'':
+			s.skipQuoted('\'', s.isEscapeString())
+		case c == '"':
+			s.skipQuoted('"', false)
+		case c == '$':
+			s.skipDollarQuoted()
+		default:
+			s.advance(1)
+		}
+	}
+
+	if current != nil {
+		emit(len(chunk))
+	}
+
+	return statements
+}
+
+// advance moves the position n bytes forward.
+func (s *scanner) advance(n int) {
+	end := min(s.pos+n, len(s.src))
+
+	for ; s.pos < end; s.pos++ {
+		switch c := s.src[s.pos]; {
+		case c == '\n':
+			s.line++
+			s.column = 1
+		case utf8.RuneStart(c):
+			s.column++
+		}
+	}
+}
+
+// skipLineComment moves to the end of the line, leaving the line break.
+func (s *scanner) skipLineComment() {
+	end := strings.IndexByte(s.src[s.pos:], '\n')
+	if end < 0 {
+		end = len(s.src) - s.pos
+	}
+	s.advance(end)
+}
+
+// skipBlockComment moves past the block comment, which can be nested.
+func (s *scanner) skipBlockComment() {
+	depth := 0
+
+	for s.pos < len(s.src) {
+		switch {
+		case strings.HasPrefix(s.src[s.pos:], "/*"):
+			depth++
+			s.advance(2)
+		case strings.HasPrefix(s.src[s.pos:], "*/"):
+			depth--
+			s.advance(2)
+			if depth == 0 {
+				return
+			}
+		default:
+			s.advance(1)
+		}
+	}
+}
+
+// skipQuoted moves past a string literal or quoted identifier. The quote is escaped by doubling it,
+// or with a backslash in escape strings, such as E'it\'s'.
+func (s *scanner) skipQuoted(quote byte, backslash bool) {
+	s.advance(1)
+
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case backslash && c == '\\':
+			s.advance(2)
+		case c == quote && s.pos+1 < len(s.src) && s.src[s.pos+1] == quote:
+			s.advance(2)
+		case c == quote:
+			s.advance(1)
+			return
+		default:
+			s.advance(1)
+		}
+	}
+}
+
+// skipDollarQuoted moves past a dollar quoted string, such as $$body$$ or $fn$body$fn$.
+// A dollar sign that doesn't start a tag, such as in $1 or an identifier, is skipped alone.
+func (s *scanner) skipDollarQuoted() {
+	tag, ok := s.dollarTag()
+	if !ok {
+		s.advance(1)
+		return
+	}
+
+	end := strings.Index(s.src[s.pos+len(tag):], tag)
+	if end < 0 {
+		s.advance(len(s.src) - s.pos)
+		return
+	}
+	s.advance(len(tag) + end + len(tag))
+}
+
+// dollarTag returns the opening tag of a dollar quoted string at the position, including both dollar signs.
+func (s *scanner) dollarTag() (string, bool) {
+	if s.pos > 0 && isIdentifier(s.src[s.pos-1]) {
+		return "", false
+	}
+
+	for i := s.pos + 1; i < len(s.src); i++ {
+		switch c := s.src[i]; {
+		case c == '$':
+			return s.src[s.pos : i+1], true
+		case c >= '0' && c <= '9' && i == s.pos+1:
+			// Tags can't start with a digit, so it's a positional parameter.
+			return "", false
+		case !isIdentifier(c):
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isEscapeString tells if the quote at the position opens an escape string, such as E'\n'.
+func (s *scanner) isEscapeString() bool {
+	if s.pos == 0 || (s.src[s.pos-1] != 'E' && s.src[s.pos-1] != 'e') {
+		return false
+	}
+	return s.pos == 1 || !isIdentifier(s.src[s.pos-2])
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// isIdentifier tells if the byte can be part of an unquoted identifier.
+// Multibyte characters are allowed in identifiers, so any of their bytes is.
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,271 @@
+// Package sqlscript parses the SQL scripts of migrations, and the codemigrate directives in their comments.
+//
+// Directives are comment lines starting with "-- +codemigrate":
+//
+//   - Up and Down start the section of each direction, so a single file can carry both.
+//   - NoTransaction runs the migration outside a transaction.
+//   - StatementBegin and StatementEnd delimit a statement that is sent as a whole,
+//     such as a function body using BEGIN ATOMIC.
+//
+// Outside these blocks, scripts are split into statements at semicolons,
+// ignoring the ones in string literals, quoted identifiers, dollar quoted strings and comments.
+package sqlscript
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sonalys/codemigrate/migrate"
+)
+
+// Directives recognized in script comments, after the "-- +codemigrate" prefix.
+const (
+	DirectiveUp             = "Up"
+	DirectiveDown           = "Down"
+	DirectiveNoTransaction  = "NoTransaction"
+	DirectiveStatementBegin = "StatementBegin"
+	DirectiveStatementEnd   = "StatementEnd"
+)
+
+// directivePrefix starts every directive line.
+const directivePrefix = "-- +codemigrate"
+
+// ErrDirective when a script has an unknown or misplaced directive.
+const ErrDirective = migrate.StringError("invalid codemigrate directive")
+
+type (
+	// Script is a parsed migration script.
+	Script struct {
+		// Up is the section applying the migration.
+		Up Section
+		// Down is the section reverting the migration.
+		Down Section
+		// NoTransaction is set by the NoTransaction directive.
+		NoTransaction bool
+	}
+
+	// Section is the content of a script for a single direction.
+	Section struct {
+		// Text is the content of the section, without the Up and Down directives.
+		// For a script without sections, it's the whole script.
+		Text string
+		// Statements lists the statements of the section, in order.
+		Statements []Statement
+	}
+
+	// Statement is a piece of the script sent to the database in a single call.
+	Statement struct {
+		// SQL is the statement text, without the comments and whitespace around it.
+		SQL string
+		// Line is the line of the script where the statement starts, starting at 1.
+		Line int
+		// Column is the column of the script where the statement starts, in characters, starting at 1.
+		Column int
+	}
+
+	// StatementError is returned when a statement of a script fails, locating it in the script.
+	StatementError struct {
+		// File is the path of the script. It's empty for scripts not read from files.
+		File string
+		// Statement is the index of the statement in its section, starting at 1.
+		Statement int
+		// Line and Column locate the error in the script, or the statement when the database doesn't report a position.
+		Line   int
+		Column int
+		// Err is the error returned by the database.
+		Err error
+	}
+
+	// parser holds the state of Parse.
+	parser struct {
+		script  *Script
+		current *Section
+		// sections tells if the script has Up or Down directives.
+		sections bool
+		seen     map[*Section]bool
+
+		chunk     strings.Builder
+		chunkLine int
+		inBlock   bool
+	}
+)
+
+var _ error = &StatementError{}
+
+// Parse parses the script, splitting it into sections and statements.
+// Content outside Up and Down sections belongs to the given direction,
+// so a script without sections is entirely applied, or reverted.
+// Statements between StatementBegin and StatementEnd are kept whole. Any other content is split at semicolons.
+func Parse(content string, direction migrate.Direction) (*Script, error) {
+	p := &parser{
+		script: &Script{},
+		seen:   make(map[*Section]bool),
+	}
+
+	p.current = p.section(direction)
+
+	line := 0
+	for text := range strings.Lines(content) {
+		line++
+
+		directive, ok := parseDirective(text)
+		if !ok {
+			p.write(text, line)
+			continue
+		}
+
+		if err := p.directive(directive, line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	if p.inBlock {
+		return nil, fmt.Errorf("line %d: %w: %s without %s", line, ErrDirective, DirectiveStatementBegin, DirectiveStatementEnd)
+	}
+
+	p.flush()
+
+	if !p.sections {
+		p.current.Text = content
+	}
+
+	return p.script, nil
+}
+
+// parseDirective returns the directive of the line, if it's a directive line.
+func parseDirective(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+
+	directive, ok := strings.CutPrefix(line, directivePrefix)
+	if !ok || (directive != "" && directive[0] != ' ' && directive[0] != '\t') {
+		return "", false
+	}
+
+	return strings.TrimSpace(directive), true
+}
+
+func (p *parser) section(direction migrate.Direction) *Section {
+	if direction == migrate.DirectionDown {
+		return &p.script.Down
+	}
+	return &p.script.Up
+}
+
+func (p *parser) directive(directive string, line int) error {
+	switch directive {
+	case DirectiveUp, DirectiveDown:
+		if p.inBlock {
+			return fmt.Errorf("%w: %s inside a statement block", ErrDirective, directive)
+		}
+
+		if !p.sections && strings.TrimSpace(stripComments(p.current.Text+p.chunk.String())) != "" {
+			return fmt.Errorf("%w: statements before the %s section", ErrDirective, directive)
+		}
+
+		p.flush()
+
+		if !p.sections {
+			// Content before the first section is only comments.
+			p.current.Text = ""
+			p.current.Statements = nil
+			p.sections = true
+		}
+
+		section := p.section(migrate.Direction(strings.ToLower(directive)))
+		if p.seen[section] {
+			return fmt.Errorf("%w: duplicate %s section", ErrDirective, directive)
+		}
+
+		p.seen[section] = true
+		p.current = section
+	case DirectiveNoTransaction:
+		p.script.NoTransaction = true
+	case DirectiveStatementBegin:
+		if p.inBlock {
+			return fmt.Errorf("%w: nested %s", ErrDirective, directive)
+		}
+
+		p.flush()
+		p.inBlock = true
+	case DirectiveStatementEnd:
+		if !p.inBlock {
+			return fmt.Errorf("%w: %s without %s", ErrDirective, directive, DirectiveStatementBegin)
+		}
+
+		p.flush()
+		p.inBlock = false
+	default:
+		return fmt.Errorf("%w: unknown directive %q", ErrDirective, directive)
+	}
+
+	return nil
+}
+
+// write adds the line to the current section and statement.
+func (p *parser) write(text string, line int) {
+	p.current.Text += text
+
+	if p.chunk.Len() == 0 {
+		p.chunkLine = line
+	}
+	p.chunk.WriteString(text)
+}
+
+// flush splits the current chunk into statements, ignoring blanks and comments.
+func (p *parser) flush() {
+	defer p.chunk.Reset()
+
+	statements := split(p.chunk.String(), p.chunkLine, p.inBlock)
+	p.current.Statements = append(p.current.Statements, statements...)
+}
+
+// Position returns the line and column of the script for a position in the statement,
+// counted in characters starting at 1, as reported by PostgreSQL errors.
+// Positions past the end of the statement return its last character.
+func (s Statement) Position(position int) (line, column int) {
+	line, column = s.Line, s.Column
+
+	index := 1
+	for i, r := range s.SQL {
+		if index == position || i+utf8.RuneLen(r) == len(s.SQL) {
+			return line, column
+		}
+
+		index++
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+
+	return line, column
+}
+
+func (e *StatementError) Error() string {
+	location := fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+	if e.File != "" {
+		location = fmt.Sprintf("%s:%d:%d", e.File, e.Line, e.Column)
+	}
+	return fmt.Sprintf("%s: statement %d: %s", location, e.Statement, e.Err)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// stripComments removes line comments, to tell if the content has any statement.
+func stripComments(content string) string {
+	var builder strings.Builder
+
+	for line := range strings.Lines(content) {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		builder.WriteString(line)
+	}
+
+	return builder.String()
+}
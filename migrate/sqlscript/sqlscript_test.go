@@ -0,0 +1,137 @@
+package sqlscript_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sonalys/codemigrate/migrate"
+	"github.com/sonalys/codemigrate/migrate/sqlscript"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("success: script without directives", func(t *testing.T) {
+		content := "CREATE TABLE users (id INT);\nCREATE INDEX ON users (id);\n"
+
+		script, err := sqlscript.Parse(content, migrate.DirectionDown)
+		require.NoError(t, err)
+		require.Empty(t, script.Up.Statements)
+		require.Equal(t, content, script.Down.Text)
+		require.Equal(t, []sqlscript.Statement{
+			{SQL: "CREATE TABLE users (id INT);", Line: 1, Column: 1},
+			{SQL: "CREATE INDEX ON users (id);", Line: 2, Column: 1},
+		}, script.Down.Statements)
+		require.False(t, script.NoTransaction)
+	})
+
+	t.Run("success: up and down sections", func(t *testing.T) {
+		content := "-- creates users\n" +
+			"-- +codemigrate Up\n" +
+			"CREATE TABLE users (id INT);\n" +
+			"-- +codemigrate StatementBegin\n" +
+			"CREATE FUNCTION one() RETURNS INT LANGUAGE SQL\n" +
+			"BEGIN ATOMIC SELECT 1; END;\n" +
+			"-- +codemigrate StatementEnd\n" +
+			"-- +codemigrate Down\n" +
+			"DROP TABLE users;\n"
+
+		script, err := sqlscript.Parse(content, migrate.DirectionUp)
+		require.NoError(t, err)
+		require.Equal(t, []sqlscript.Statement{
+			{SQL: "CREATE TABLE users (id INT);", Line: 3, Column: 1},
+			{SQL: "CREATE FUNCTION one() RETURNS INT LANGUAGE SQL\nBEGIN ATOMIC SELECT 1; END;", Line: 5, Column: 1},
+		}, script.Up.Statements)
+		require.Equal(t, []sqlscript.Statement{{SQL: "DROP TABLE users;", Line: 9, Column: 1}}, script.Down.Statements)
+		require.Equal(t, "DROP TABLE users;\n", script.Down.Text)
+	})
+
+	t.Run("success: no transaction", func(t *testing.T) {
+		script, err := sqlscript.Parse("-- +codemigrate NoTransaction\nCREATE INDEX CONCURRENTLY idx ON users (id)", migrate.DirectionUp)
+		require.NoError(t, err)
+		require.True(t, script.NoTransaction)
+		require.Len(t, script.Up.Statements, 1)
+	})
+
+	t.Run("success: ignores similar comments", func(t *testing.T) {
+		script, err := sqlscript.Parse("-- +codemigrateUp\nSELECT 1", migrate.DirectionUp)
+		require.NoError(t, err)
+		require.Len(t, script.Up.Statements, 1)
+	})
+
+	t.Run("success: splits statements outside quotes and comments", func(t *testing.T) {
+		content := "-- header; not a statement\n" +
+			"INSERT INTO notes VALUES ('a;b', E'it\\'s;', \"odd;name\"); SELECT 1 /* c; /* nested; */ */;\n" +
+			"CREATE FUNCTION f() RETURNS INT AS $fn$ SELECT 1; $fn$ LANGUAGE SQL;\n" +
+			"SELECT $$;$$, $1, a$b\n" +
+			"-- trailing; comment\n"
+
+		script, err := sqlscript.Parse(content, migrate.DirectionUp)
+		require.NoError(t, err)
+		require.Equal(t, []sqlscript.Statement{
+			{SQL: "INSERT INTO notes VALUES ('a;b', E'it\\'s;', \"odd;name\");", Line: 2, Column: 1},
+			{SQL: "SELECT 1 /* c; /* nested; */ */;", Line: 2, Column: 58},
+			{SQL: "CREATE FUNCTION f() RETURNS INT AS $fn$ SELECT 1; $fn$ LANGUAGE SQL;", Line: 3, Column: 1},
+			{SQL: "SELECT $$;$$, $1, a$b\n-- trailing; comment", Line: 4, Column: 1},
+		}, script.Up.Statements)
+	})
+
+	t.Run("success: comments only", func(t *testing.T) {
+		script, err := sqlscript.Parse("-- nothing to revert;\n/* really; */\n", migrate.DirectionDown)
+		require.NoError(t, err)
+		require.Empty(t, script.Down.Statements)
+	})
+
+	t.Run("error: invalid directives", func(t *testing.T) {
+		tests := map[string]string{
+			"unknown directive":    "-- +codemigrate Sideways\n",
+			"statements before":    "SELECT 1;\n-- +codemigrate Up\n",
+			"duplicate section":    "-- +codemigrate Up\n-- +codemigrate Up\n",
+			"unterminated block":   "-- +codemigrate StatementBegin\nSELECT 1;\n",
+			"unopened block":       "-- +codemigrate StatementEnd\n",
+			"nested block":         "-- +codemigrate StatementBegin\n-- +codemigrate StatementBegin\n",
+			"section inside block": "-- +codemigrate StatementBegin\n-- +codemigrate Down\n",
+		}
+
+		for name, content := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := sqlscript.Parse(content, migrate.DirectionUp)
+				require.ErrorIs(t, err, sqlscript.ErrDirective)
+			})
+		}
+	})
+}
+
+func TestStatement_Position(t *testing.T) {
+	statement := sqlscript.Statement{
+		SQL:    "SELECT 'é',\n  missing",
+		Line:   3,
+		Column: 5,
+	}
+
+	tests := []struct {
+		position     int
+		line, column int
+	}{
+		{position: 1, line: 3, column: 5},
+		{position: 10, line: 3, column: 14},
+		{position: 15, line: 4, column: 3},
+		{position: 100, line: 4, column: 9},
+	}
+
+	for _, tt := range tests {
+		line, column := statement.Position(tt.position)
+		require.Equal(t, tt.line, line, "position %d", tt.position)
+		require.Equal(t, tt.column, column, "position %d", tt.position)
+	}
+}
+
+func TestStatementError(t *testing.T) {
+	cause := errors.New("syntax error")
+
+	err := &sqlscript.StatementError{File: "migrations/0001_users.up.sql", Statement: 2, Line: 4, Column: 3, Err: cause}
+	require.ErrorIs(t, err, cause)
+	require.Equal(t, "migrations/0001_users.up.sql:4:3: statement 2: syntax error", err.Error())
+
+	err.File = ""
+	require.Equal(t, "line 4, column 3: statement 2: syntax error", err.Error())
+}
@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sonalys/codemigrate/migrate"
+
+// Span attributes set by the migrator.
+const (
+	AttributeVersion       = attribute.Key("codemigrate.version")
+	AttributeDirection     = attribute.Key("codemigrate.direction")
+	AttributeTargetVersion = attribute.Key("codemigrate.target_version")
+	AttributeSteps         = attribute.Key("codemigrate.steps")
+)
+
+// startProcessSpan starts the parent span of an Up or Down call.
+func (m migrator[T]) startProcessSpan(ctx context.Context, direction Direction, targetVersion int64, steps int) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		AttributeDirection.String(string(direction)),
+		AttributeTargetVersion.Int64(targetVersion),
+	}
+	if steps > 0 {
+		attributes = append(attributes, AttributeSteps.Int(steps))
+	}
+
+	return m.config.tracer.Start(ctx, "migrate."+string(direction), trace.WithAttributes(attributes...))
+}
+
+// startStepSpan starts the span of a single migration, as a child of the process span.
+func (m migrator[T]) startStepSpan(ctx context.Context, next *step[T]) (context.Context, trace.Span) {
+	return m.config.tracer.Start(ctx, "migrate.migration",
+		trace.WithAttributes(
+			AttributeVersion.Int64(next.migration.Version()),
+			AttributeDirection.String(string(next.direction)),
+		),
+	)
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}